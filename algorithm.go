@@ -0,0 +1,443 @@
+package dbroute
+
+import (
+	"fmt"
+	"gorm/dbroute/util/str"
+	"reflect"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+// 内置分片算法
+const (
+	AlgorithmMod           = "MOD"
+	AlgorithmHashMod       = "HASH_MOD"
+	AlgorithmBoundaryRange = "BOUNDARY_RANGE"
+	AlgorithmVolumeRange   = "VOLUME_RANGE"
+	AlgorithmInterval      = "INTERVAL"
+)
+
+// 时间分片的间隔单位
+const (
+	IntervalYears  = "YEARS"
+	IntervalMonths = "MONTHS"
+	IntervalDays   = "DAYS"
+	IntervalHours  = "HOURS"
+)
+
+// ShardingAlgorithm 分片算法，按分片键的取值计算分片名后缀
+type ShardingAlgorithm interface {
+	DoSharding(values map[string]interface{}) (string, error)
+}
+
+// ShardingAlgorithmFactory 按属性创建分片算法，属性不合法时返回错误
+type ShardingAlgorithmFactory func(props ShardingAlgorithmProps) (ShardingAlgorithm, error)
+
+// ShardingAlgorithmConfig 分片算法配置，Type 为注册的算法名
+type ShardingAlgorithmConfig struct {
+	Type string `json:"type"`
+	// Prefix 分片名前缀，与算法得到的后缀拼接为数据源名或物理表名，分表时默认为 表名_
+	Prefix string                 `json:"prefix"`
+	Props  ShardingAlgorithmProps `json:"props"`
+
+	// algorithm 注册时按配置创建的算法，执行时复用
+	algorithm ShardingAlgorithm
+}
+
+// ShardingAlgorithmProps 分片算法属性
+type ShardingAlgorithmProps struct {
+	// ShardingCount MOD、HASH_MOD 的分片数
+	ShardingCount int64 `json:"sharding-count"`
+	// ShardingRanges BOUNDARY_RANGE 的分界值，升序，如 [1, 5, 10] 划分为 <1、[1,5)、[5,10)、>=10 四个分片
+	ShardingRanges []int64 `json:"sharding-ranges"`
+	// RangeLower、RangeUpper、ShardingVolume VOLUME_RANGE 的下界、上界与每个分片的容量，下界以下为分片 0，上界及以上为最后一个分片
+	RangeLower     int64 `json:"range-lower"`
+	RangeUpper     int64 `json:"range-upper"`
+	ShardingVolume int64 `json:"sharding-volume"`
+	// INTERVAL 的时间格式（Go layout）、起止时间、分片后缀格式及间隔
+	DatetimePattern        string `json:"datetime-pattern"`
+	DatetimeLower          string `json:"datetime-lower"`
+	DatetimeUpper          string `json:"datetime-upper"`
+	ShardingSuffixPattern  string `json:"sharding-suffix-pattern"`
+	DatetimeIntervalAmount int    `json:"datetime-interval-amount"`
+	DatetimeIntervalUnit   string `json:"datetime-interval-unit"`
+	// DatetimeTimeZone 解析与划分时间使用的时区，如 Asia/Shanghai，默认 UTC
+	DatetimeTimeZone string `json:"datetime-time-zone"`
+}
+
+var (
+	shardingAlgorithmsMu sync.RWMutex
+	shardingAlgorithms   = map[string]ShardingAlgorithmFactory{
+		AlgorithmMod:           newModAlgorithm,
+		AlgorithmHashMod:       newHashModAlgorithm,
+		AlgorithmBoundaryRange: newBoundaryRangeAlgorithm,
+		AlgorithmVolumeRange:   newVolumeRangeAlgorithm,
+		AlgorithmInterval:      newIntervalAlgorithm,
+	}
+)
+
+// RegisterShardingAlgorithm 注册分片算法，同名时覆盖
+func RegisterShardingAlgorithm(name string, factory ShardingAlgorithmFactory) {
+	shardingAlgorithmsMu.Lock()
+	defer shardingAlgorithmsMu.Unlock()
+	shardingAlgorithms[strings.ToUpper(name)] = factory
+}
+
+// NewShardingAlgorithm 按配置创建分片算法并校验属性
+func NewShardingAlgorithm(config ShardingAlgorithmConfig) (ShardingAlgorithm, error) {
+	shardingAlgorithmsMu.RLock()
+	factory, ok := shardingAlgorithms[strings.ToUpper(config.Type)]
+	shardingAlgorithmsMu.RUnlock()
+	if !ok {
+		return nil, fmt.Errorf("sharding algorithm %q not registered", config.Type)
+	}
+	algorithm, err := factory(config.Props)
+	if err != nil {
+		return nil, fmt.Errorf("sharding algorithm %s: %w", config.Type, err)
+	}
+	return algorithm, nil
+}
+
+// sharding
+//
+//	@Description: 分片键取值到分片名的计算，属性不合法或取值无法计算时返回错误
+//	@param defaultPrefix	未配置前缀时使用
+//	@return shardingFunc
+func (c *ShardingAlgorithmConfig) sharding(defaultPrefix string) shardingFunc {
+	algorithm := c.algorithm
+	if algorithm == nil {
+		// 未经注册校验的配置，如直接使用策略
+		var err error
+		if algorithm, err = NewShardingAlgorithm(*c); err != nil {
+			return func(map[string]interface{}) (string, error) {
+				return "", err
+			}
+		}
+	}
+	prefix := c.Prefix
+	if prefix == "" {
+		prefix = defaultPrefix
+	}
+	return func(values map[string]interface{}) (string, error) {
+		suffix, err := algorithm.DoSharding(values)
+		if err != nil {
+			return "", err
+		}
+		return prefix + suffix, nil
+	}
+}
+
+// singleValueAlgorithm 单列分片算法
+type singleValueAlgorithm func(value interface{}) (string, error)
+
+func (a singleValueAlgorithm) DoSharding(values map[string]interface{}) (string, error) {
+	if len(values) != 1 {
+		return "", fmt.Errorf("expect exactly one sharding column, got %d", len(values))
+	}
+	for _, value := range values {
+		return a(value)
+	}
+	return "", nil
+}
+
+func newModAlgorithm(props ShardingAlgorithmProps) (ShardingAlgorithm, error) {
+	if props.ShardingCount <= 0 {
+		return nil, fmt.Errorf("sharding-count must be positive, got %d", props.ShardingCount)
+	}
+	return singleValueAlgorithm(func(value interface{}) (string, error) {
+		v, err := toInt64(value)
+		if err != nil {
+			return "", err
+		}
+		mod := v % props.ShardingCount
+		if mod < 0 {
+			mod += props.ShardingCount
+		}
+		return strconv.FormatInt(mod, 10), nil
+	}), nil
+}
+
+func newHashModAlgorithm(props ShardingAlgorithmProps) (ShardingAlgorithm, error) {
+	if props.ShardingCount <= 0 || props.ShardingCount > 1<<31-1 {
+		return nil, fmt.Errorf("sharding-count must be positive, got %d", props.ShardingCount)
+	}
+	return singleValueAlgorithm(func(value interface{}) (string, error) {
+		return strconv.Itoa(str.HashMode(toString(value), int32(props.ShardingCount))), nil
+	}), nil
+}
+
+func newBoundaryRangeAlgorithm(props ShardingAlgorithmProps) (ShardingAlgorithm, error) {
+	if len(props.ShardingRanges) == 0 {
+		return nil, fmt.Errorf("sharding-ranges is required")
+	}
+	for i := 1; i < len(props.ShardingRanges); i++ {
+		if props.ShardingRanges[i] <= props.ShardingRanges[i-1] {
+			return nil, fmt.Errorf("sharding-ranges must be ascending: %v", props.ShardingRanges)
+		}
+	}
+	return singleValueAlgorithm(func(value interface{}) (string, error) {
+		v, err := toInt64(value)
+		if err != nil {
+			return "", err
+		}
+		// 不大于取值的分界值个数即为分片序号
+		index := sort.Search(len(props.ShardingRanges), func(i int) bool { return props.ShardingRanges[i] > v })
+		return strconv.Itoa(index), nil
+	}), nil
+}
+
+func newVolumeRangeAlgorithm(props ShardingAlgorithmProps) (ShardingAlgorithm, error) {
+	if props.ShardingVolume <= 0 {
+		return nil, fmt.Errorf("sharding-volume must be positive, got %d", props.ShardingVolume)
+	}
+	if props.RangeUpper <= props.RangeLower {
+		return nil, fmt.Errorf("range-upper %d must be greater than range-lower %d", props.RangeUpper, props.RangeLower)
+	}
+	last := (props.RangeUpper-props.RangeLower+props.ShardingVolume-1)/props.ShardingVolume + 1
+	return singleValueAlgorithm(func(value interface{}) (string, error) {
+		v, err := toInt64(value)
+		if err != nil {
+			return "", err
+		}
+		switch {
+		case v < props.RangeLower:
+			return "0", nil
+		case v >= props.RangeUpper:
+			return strconv.FormatInt(last, 10), nil
+		}
+		return strconv.FormatInt((v-props.RangeLower)/props.ShardingVolume+1, 10), nil
+	}), nil
+}
+
+// intervalAlgorithm 按时间间隔分片，后缀为所在间隔起始时间按 ShardingSuffixPattern 格式化
+type intervalAlgorithm struct {
+	props        ShardingAlgorithmProps
+	location     *time.Location
+	lower, upper time.Time
+}
+
+// 取值与时间格式不一致时依次尝试的格式，语句参数中的时间在 Explain 前转为 UTC 的 RFC3339 格式
+var datetimeLayouts = []string{"2006-01-02 15:04:05.999999999", "2006-01-02", time.RFC3339Nano}
+
+func newIntervalAlgorithm(props ShardingAlgorithmProps) (ShardingAlgorithm, error) {
+	a, err := compileIntervalAlgorithm(props)
+	if err != nil {
+		return nil, err
+	}
+	return singleValueAlgorithm(a.doSharding), nil
+}
+
+func compileIntervalAlgorithm(props ShardingAlgorithmProps) (*intervalAlgorithm, error) {
+	if props.DatetimePattern == "" || props.ShardingSuffixPattern == "" {
+		return nil, fmt.Errorf("datetime-pattern and sharding-suffix-pattern are required")
+	}
+	if props.DatetimeIntervalAmount == 0 {
+		props.DatetimeIntervalAmount = 1
+	}
+	if props.DatetimeIntervalAmount < 0 {
+		return nil, fmt.Errorf("datetime-interval-amount must be positive, got %d", props.DatetimeIntervalAmount)
+	}
+	if props.DatetimeIntervalUnit == "" {
+		props.DatetimeIntervalUnit = IntervalDays
+	}
+	switch props.DatetimeIntervalUnit = strings.ToUpper(props.DatetimeIntervalUnit); props.DatetimeIntervalUnit {
+	case IntervalYears, IntervalMonths, IntervalDays, IntervalHours:
+	default:
+		return nil, fmt.Errorf("unsupported datetime-interval-unit %q", props.DatetimeIntervalUnit)
+	}
+	a := &intervalAlgorithm{props: props, location: time.UTC}
+	if props.DatetimeTimeZone != "" {
+		location, err := time.LoadLocation(props.DatetimeTimeZone)
+		if err != nil {
+			return nil, fmt.Errorf("datetime-time-zone: %w", err)
+		}
+		a.location = location
+	}
+	lower, err := time.ParseInLocation(props.DatetimePattern, props.DatetimeLower, a.location)
+	if err != nil {
+		return nil, fmt.Errorf("datetime-lower: %w", err)
+	}
+	a.lower = lower
+	if props.DatetimeUpper != "" {
+		if a.upper, err = time.ParseInLocation(props.DatetimePattern, props.DatetimeUpper, a.location); err != nil {
+			return nil, fmt.Errorf("datetime-upper: %w", err)
+		}
+		if a.upper.Before(a.lower) {
+			return nil, fmt.Errorf("datetime-upper %s is before datetime-lower %s", props.DatetimeUpper, props.DatetimeLower)
+		}
+	}
+	return a, nil
+}
+
+func (a *intervalAlgorithm) doSharding(value interface{}) (string, error) {
+	t, err := a.parse(value)
+	if err != nil {
+		return "", err
+	}
+	if t.Before(a.lower) || (!a.upper.IsZero() && t.After(a.upper)) {
+		return "", fmt.Errorf("datetime %s out of sharding range", t.Format(a.props.DatetimePattern))
+	}
+	return a.suffix(t), nil
+}
+
+// parse 分片键取值转为所配置时区的时间
+func (a *intervalAlgorithm) parse(value interface{}) (time.Time, error) {
+	if t, ok := value.(time.Time); ok {
+		return t.In(a.location), nil
+	}
+	s := toString(value)
+	t, err := time.ParseInLocation(a.props.DatetimePattern, s, a.location)
+	for _, layout := range datetimeLayouts {
+		if err == nil {
+			break
+		}
+		t, err = time.ParseInLocation(layout, s, a.location)
+	}
+	if err != nil {
+		return t, fmt.Errorf("sharding value %v is not a datetime of pattern %s", value, a.props.DatetimePattern)
+	}
+	// 带时区的取值（如 RFC3339）转为所配置的时区后再划分间隔
+	return t.In(a.location), nil
+}
+
+func (a *intervalAlgorithm) suffix(t time.Time) string {
+	return a.intervalStart(t).Format(a.props.ShardingSuffixPattern)
+}
+
+// upperBound 时间上界，未配置时为当前时间
+func (a *intervalAlgorithm) upperBound() time.Time {
+	if a.upper.IsZero() {
+		return time.Now().In(a.location)
+	}
+	return a.upper
+}
+
+// rangeSuffixes 时间范围涉及的全部间隔的后缀，范围限定在上下界之内
+func (a *intervalAlgorithm) rangeSuffixes(from, to time.Time) []string {
+	if from.Before(a.lower) {
+		from = a.lower
+	}
+	if upper := a.upperBound(); to.After(upper) {
+		to = upper
+	}
+	if from.After(to) {
+		return nil
+	}
+	var suffixes []string
+	for start := a.intervalStart(from); !start.After(to); start = a.next(start) {
+		suffixes = append(suffixes, start.Format(a.props.ShardingSuffixPattern))
+	}
+	return suffixes
+}
+
+// next 下一个间隔的起始时间
+func (a *intervalAlgorithm) next(start time.Time) time.Time {
+	amount := a.props.DatetimeIntervalAmount
+	switch a.props.DatetimeIntervalUnit {
+	case IntervalYears:
+		return start.AddDate(amount, 0, 0)
+	case IntervalMonths:
+		return start.AddDate(0, amount, 0)
+	case IntervalHours:
+		return start.Add(time.Duration(amount) * time.Hour)
+	}
+	return start.AddDate(0, 0, amount)
+}
+
+// intervalStart 时间所在间隔的起始时间，自 DatetimeLower 起按间隔划分
+func (a *intervalAlgorithm) intervalStart(t time.Time) time.Time {
+	amount := a.props.DatetimeIntervalAmount
+	switch a.props.DatetimeIntervalUnit {
+	case IntervalYears:
+		n := (t.Year() - a.lower.Year()) / amount * amount
+		return a.lower.AddDate(n, 0, 0)
+	case IntervalMonths:
+		months := (t.Year()-a.lower.Year())*12 + int(t.Month()) - int(a.lower.Month())
+		if t.Before(a.lower.AddDate(0, months, 0)) {
+			months--
+		}
+		return a.lower.AddDate(0, months/amount*amount, 0)
+	case IntervalHours:
+		n := int(t.Sub(a.lower)/time.Hour) / amount * amount
+		return a.lower.Add(time.Duration(n) * time.Hour)
+	}
+	days := int(t.Sub(a.lower) / (24 * time.Hour))
+	if t.Before(a.lower.AddDate(0, 0, days)) {
+		// 跨夏令时切换时按日历日修正
+		days--
+	}
+	return a.lower.AddDate(0, 0, days/amount*amount)
+}
+
+// toInt64 分片键取值转为整数
+func toInt64(value interface{}) (int64, error) {
+	switch v := value.(type) {
+	case int64:
+		return v, nil
+	case int:
+		return int64(v), nil
+	case float64:
+		if v == float64(int64(v)) {
+			return int64(v), nil
+		}
+	case string:
+		return strconv.ParseInt(v, 10, 64)
+	case []byte:
+		return strconv.ParseInt(string(v), 10, 64)
+	}
+	switch rv := reflect.ValueOf(value); rv.Kind() {
+	case reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32, reflect.Int64:
+		return rv.Int(), nil
+	case reflect.Uint, reflect.Uint8, reflect.Uint16, reflect.Uint32, reflect.Uint64:
+		return int64(rv.Uint()), nil
+	}
+	return 0, fmt.Errorf("sharding value %v is not an integer", value)
+}
+
+// conditionSuffixes 分片条件涉及的全部间隔的后缀并排序去重，超出起止时间的取值被忽略（全部超出时为空），无法确定时为起止时间内的全部间隔
+func (a *intervalAlgorithm) conditionSuffixes(cond ShardingCondition) []string {
+	all := a.rangeSuffixes(a.lower, a.upperBound())
+	if cond.All {
+		return all
+	}
+	seen := make(map[string]bool)
+	var suffixes []string
+	add := func(suffix string) {
+		if !seen[suffix] {
+			seen[suffix] = true
+			suffixes = append(suffixes, suffix)
+		}
+	}
+	for _, value := range cond.Values {
+		if t, err := a.parse(value); err != nil {
+			return all
+		} else if !t.Before(a.lower) && !t.After(a.upperBound()) {
+			add(a.suffix(t))
+		}
+	}
+	for _, r := range cond.Ranges {
+		from, to := a.lower, a.upperBound()
+		if r.Lower != nil {
+			t, err := a.parse(r.Lower)
+			if err != nil {
+				return all
+			}
+			from = t
+		}
+		if r.Upper != nil {
+			t, err := a.parse(r.Upper)
+			if err != nil {
+				return all
+			}
+			to = t
+		}
+		for _, suffix := range a.rangeSuffixes(from, to) {
+			add(suffix)
+		}
+	}
+	sort.Strings(suffixes)
+	return suffixes
+}
@@ -0,0 +1,103 @@
+package dbroute
+
+import (
+	"fmt"
+	"testing"
+)
+
+func TestShardingAlgorithms(t *testing.T) {
+	interval := ShardingAlgorithmProps{
+		DatetimePattern:       "2006-01-02 15:04:05",
+		DatetimeLower:         "2026-01-01 00:00:00",
+		DatetimeUpper:         "2026-12-31 23:59:59",
+		ShardingSuffixPattern: "200601",
+		DatetimeIntervalUnit:  IntervalMonths,
+	}
+	shanghai := interval
+	shanghai.DatetimeTimeZone = "Asia/Shanghai"
+	tests := []struct {
+		name    string
+		config  ShardingAlgorithmConfig
+		value   interface{}
+		want    string
+		wantErr bool
+	}{
+		{name: "mod", config: ShardingAlgorithmConfig{Type: AlgorithmMod, Props: ShardingAlgorithmProps{ShardingCount: 4}}, value: int64(7), want: "3"},
+		{name: "mod negative", config: ShardingAlgorithmConfig{Type: AlgorithmMod, Props: ShardingAlgorithmProps{ShardingCount: 4}}, value: int64(-1), want: "3"},
+		{name: "mod lower case type", config: ShardingAlgorithmConfig{Type: "mod", Props: ShardingAlgorithmProps{ShardingCount: 4}}, value: "10", want: "2"},
+		{name: "hash mod", config: ShardingAlgorithmConfig{Type: AlgorithmHashMod, Props: ShardingAlgorithmProps{ShardingCount: 4}}, value: "hello", want: "2"},
+		{name: "hash mod number", config: ShardingAlgorithmConfig{Type: AlgorithmHashMod, Props: ShardingAlgorithmProps{ShardingCount: 7}}, value: int64(1234567), want: "6"},
+		{name: "boundary range below", config: ShardingAlgorithmConfig{Type: AlgorithmBoundaryRange, Props: ShardingAlgorithmProps{ShardingRanges: []int64{1, 5, 10}}}, value: int64(0), want: "0"},
+		{name: "boundary range on boundary", config: ShardingAlgorithmConfig{Type: AlgorithmBoundaryRange, Props: ShardingAlgorithmProps{ShardingRanges: []int64{1, 5, 10}}}, value: int64(5), want: "2"},
+		{name: "boundary range above", config: ShardingAlgorithmConfig{Type: AlgorithmBoundaryRange, Props: ShardingAlgorithmProps{ShardingRanges: []int64{1, 5, 10}}}, value: int64(10), want: "3"},
+		{name: "volume range below", config: ShardingAlgorithmConfig{Type: AlgorithmVolumeRange, Props: ShardingAlgorithmProps{RangeUpper: 100, ShardingVolume: 10}}, value: int64(-1), want: "0"},
+		{name: "volume range first", config: ShardingAlgorithmConfig{Type: AlgorithmVolumeRange, Props: ShardingAlgorithmProps{RangeUpper: 100, ShardingVolume: 10}}, value: int64(9), want: "1"},
+		{name: "volume range last in range", config: ShardingAlgorithmConfig{Type: AlgorithmVolumeRange, Props: ShardingAlgorithmProps{RangeUpper: 100, ShardingVolume: 10}}, value: int64(99), want: "10"},
+		{name: "volume range above", config: ShardingAlgorithmConfig{Type: AlgorithmVolumeRange, Props: ShardingAlgorithmProps{RangeUpper: 100, ShardingVolume: 10}}, value: int64(100), want: "11"},
+		{name: "interval", config: ShardingAlgorithmConfig{Type: AlgorithmInterval, Props: interval}, value: "2026-03-05 10:11:12", want: "202603"},
+		{name: "interval time zone", config: ShardingAlgorithmConfig{Type: AlgorithmInterval, Props: shanghai}, value: "2026-02-28T20:00:00Z", want: "202603"},
+		{name: "interval out of range", config: ShardingAlgorithmConfig{Type: AlgorithmInterval, Props: interval}, value: "2025-12-31 23:59:59", wantErr: true},
+		{name: "interval not a datetime", config: ShardingAlgorithmConfig{Type: AlgorithmInterval, Props: interval}, value: "tomorrow", wantErr: true},
+		{name: "mod not an integer", config: ShardingAlgorithmConfig{Type: AlgorithmMod, Props: ShardingAlgorithmProps{ShardingCount: 4}}, value: "a", wantErr: true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			algorithm, err := NewShardingAlgorithm(tt.config)
+			if err != nil {
+				t.Fatal(err)
+			}
+			got, err := algorithm.DoSharding(map[string]interface{}{"user_id": tt.value})
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("err = %v, wantErr %v", err, tt.wantErr)
+			}
+			if got != tt.want {
+				t.Errorf("sharding = %s, want %s", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestNewShardingAlgorithmInvalid(t *testing.T) {
+	tests := []struct {
+		name   string
+		config ShardingAlgorithmConfig
+	}{
+		{name: "not registered", config: ShardingAlgorithmConfig{Type: "UNKNOWN"}},
+		{name: "mod without count", config: ShardingAlgorithmConfig{Type: AlgorithmMod}},
+		{name: "hash mod count overflow", config: ShardingAlgorithmConfig{Type: AlgorithmHashMod, Props: ShardingAlgorithmProps{ShardingCount: 1 << 31}}},
+		{name: "boundary range empty", config: ShardingAlgorithmConfig{Type: AlgorithmBoundaryRange}},
+		{name: "boundary range not ascending", config: ShardingAlgorithmConfig{Type: AlgorithmBoundaryRange, Props: ShardingAlgorithmProps{ShardingRanges: []int64{5, 1}}}},
+		{name: "volume range without volume", config: ShardingAlgorithmConfig{Type: AlgorithmVolumeRange, Props: ShardingAlgorithmProps{RangeUpper: 100}}},
+		{name: "volume range upper not above lower", config: ShardingAlgorithmConfig{Type: AlgorithmVolumeRange, Props: ShardingAlgorithmProps{RangeLower: 10, RangeUpper: 10, ShardingVolume: 1}}},
+		{name: "interval without pattern", config: ShardingAlgorithmConfig{Type: AlgorithmInterval}},
+		{name: "interval unit", config: ShardingAlgorithmConfig{Type: AlgorithmInterval, Props: ShardingAlgorithmProps{DatetimePattern: "2006-01-02", DatetimeLower: "2026-01-01", ShardingSuffixPattern: "200601", DatetimeIntervalUnit: "WEEKS"}}},
+		{name: "interval upper before lower", config: ShardingAlgorithmConfig{Type: AlgorithmInterval, Props: ShardingAlgorithmProps{DatetimePattern: "2006-01-02", DatetimeLower: "2026-01-01", DatetimeUpper: "2025-01-01", ShardingSuffixPattern: "200601"}}},
+		{name: "interval time zone", config: ShardingAlgorithmConfig{Type: AlgorithmInterval, Props: ShardingAlgorithmProps{DatetimePattern: "2006-01-02", DatetimeLower: "2026-01-01", ShardingSuffixPattern: "200601", DatetimeTimeZone: "Mars/Olympus"}}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if _, err := NewShardingAlgorithm(tt.config); err == nil {
+				t.Error("want error")
+			}
+		})
+	}
+}
+
+func TestRegisterShardingAlgorithm(t *testing.T) {
+	RegisterShardingAlgorithm("test_tenant", func(props ShardingAlgorithmProps) (ShardingAlgorithm, error) {
+		return singleValueAlgorithm(func(value interface{}) (string, error) {
+			return fmt.Sprintf("%v_%d", value, props.ShardingCount), nil
+		}), nil
+	})
+	config := ShardingAlgorithmConfig{Type: "TEST_TENANT", Prefix: "ds_", Props: ShardingAlgorithmProps{ShardingCount: 2}}
+	got, err := config.sharding("order_")(map[string]interface{}{"tenant_id": "a"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got != "ds_a_2" {
+		t.Errorf("sharding = %s, want ds_a_2", got)
+	}
+	if _, err = config.sharding("order_")(map[string]interface{}{"tenant_id": "a", "user_id": 1}); err == nil {
+		t.Error("want error for multiple sharding columns")
+	}
+}
@@ -0,0 +1,71 @@
+package dbroute
+
+import (
+	"fmt"
+	"gorm.io/gorm"
+	"strings"
+	"sync"
+)
+
+// tableCreation 物理表的创建，并发时只执行一次
+type tableCreation struct {
+	once sync.Once
+	err  error
+}
+
+// createTables
+//
+//	@Description: 以逻辑表为模板创建各数据节点上缺失的物理表，同一物理表只创建一次，失败时下次写入重试
+//	@param stmt
+//	@param units
+//	@return error
+func (r *route) createTables(stmt *gorm.Statement, units []routeUnit) error {
+	for _, unit := range units {
+		if unit.Table == "" || unit.Table == stmt.Table {
+			continue
+		}
+		key := string(unit.Name) + "." + unit.Table
+		value, _ := r.createdTables.LoadOrStore(key, &tableCreation{})
+		creation := value.(*tableCreation)
+		creation.once.Do(func() {
+			creation.err = r.createTable(stmt, unit, stmt.Table)
+		})
+		if creation.err != nil {
+			r.createdTables.CompareAndDelete(key, creation)
+			return fmt.Errorf("create table %s on %s: %w", unit.Table, unit.Name, creation.err)
+		}
+	}
+	return nil
+}
+
+// createTable 按数据节点的方言生成建表语句
+func (r *route) createTable(stmt *gorm.Statement, unit routeUnit, template string) error {
+	connPool := unit.ConnPool
+	if prepared, ok := connPool.(*gorm.PreparedStmtDB); ok {
+		// 建表语句不做预编译
+		connPool = prepared.ConnPool
+	}
+	dialector, ok := r.dbRoute.dialectors[connPool]
+	if !ok {
+		dialector = stmt.Dialector
+	}
+	var sql string
+	switch dialector.Name() {
+	case "mysql":
+		sql = fmt.Sprintf("CREATE TABLE IF NOT EXISTS %s LIKE %s", quoteTable(dialector, unit.Table), quoteTable(dialector, template))
+	case "postgres":
+		sql = fmt.Sprintf("CREATE TABLE IF NOT EXISTS %s (LIKE %s INCLUDING ALL)", quoteTable(dialector, unit.Table), quoteTable(dialector, template))
+	default:
+		return fmt.Errorf("auto create table is not supported for %s", dialector.Name())
+	}
+	stmt.Logger.Info(stmt.Context, "auto create table: %s", sql)
+	_, err := connPool.ExecContext(stmt.Context, sql)
+	return err
+}
+
+// quoteTable 按方言为表名加引号，带库名或模式名时由方言按 . 分别处理
+func quoteTable(dialector gorm.Dialector, table string) string {
+	var builder strings.Builder
+	dialector.QuoteTo(&builder, table)
+	return builder.String()
+}
@@ -0,0 +1,100 @@
+package dbroute
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"reflect"
+	"sort"
+	"sync"
+	"testing"
+	"time"
+
+	"gorm.io/gorm"
+	"gorm.io/gorm/logger"
+)
+
+// testMysqlDialector 按 MySQL 生成建表语句的测试方言
+type testMysqlDialector struct {
+	testDialector
+}
+
+func (testMysqlDialector) Name() string {
+	return "mysql"
+}
+
+// testDDLPool 记录执行的建表语句，failures 次之前执行失败
+type testDDLPool struct {
+	testConnPool
+	mu       sync.Mutex
+	sqls     []string
+	failures int
+}
+
+func (p *testDDLPool) ExecContext(_ context.Context, sql string, _ ...interface{}) (sql.Result, error) {
+	// 放大并发窗口
+	time.Sleep(time.Millisecond)
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	if p.failures > 0 {
+		p.failures--
+		return nil, errors.New("exec failed")
+	}
+	p.sqls = append(p.sqls, sql)
+	return testInsertResult(0), nil
+}
+
+func TestCreateTablesOnce(t *testing.T) {
+	ds0, ds1 := &testDDLPool{}, &testDDLPool{}
+	r := &route{dbRoute: &DBRoute{dialectors: map[gorm.ConnPool]gorm.Dialector{ds0: testMysqlDialector{}, ds1: testMysqlDialector{}}}}
+	units := []routeUnit{
+		{Name: "ds_0", ConnPool: ds0, Table: "order_1"},
+		{Name: "ds_0", ConnPool: ds0, Table: "order_2"},
+		{Name: "ds_1", ConnPool: ds1, Table: "order_1"},
+		{Name: "ds_1", ConnPool: ds1, Table: "order"},
+	}
+	stmt := &gorm.Statement{DB: &gorm.DB{Config: &gorm.Config{}}, Context: context.Background(), Table: "order"}
+	stmt.Logger = logger.Discard
+	var wg sync.WaitGroup
+	errs := make([]error, 50)
+	for i := range errs {
+		wg.Add(1)
+		go func(i int) {
+			defer wg.Done()
+			errs[i] = r.createTables(stmt, units)
+		}(i)
+	}
+	wg.Wait()
+	for _, err := range errs {
+		if err != nil {
+			t.Fatal(err)
+		}
+	}
+	sort.Strings(ds0.sqls)
+	if want := []string{"CREATE TABLE IF NOT EXISTS `order_1` LIKE `order`", "CREATE TABLE IF NOT EXISTS `order_2` LIKE `order`"}; !reflect.DeepEqual(ds0.sqls, want) {
+		t.Errorf("ds_0 sqls = %q, want %q", ds0.sqls, want)
+	}
+	if want := []string{"CREATE TABLE IF NOT EXISTS `order_1` LIKE `order`"}; !reflect.DeepEqual(ds1.sqls, want) {
+		t.Errorf("ds_1 sqls = %q, want %q", ds1.sqls, want)
+	}
+}
+
+// TestCreateTablesRetry 建表失败时返回错误，下次写入重新创建
+func TestCreateTablesRetry(t *testing.T) {
+	ds0 := &testDDLPool{failures: 1}
+	r := &route{dbRoute: &DBRoute{dialectors: map[gorm.ConnPool]gorm.Dialector{ds0: testMysqlDialector{}}}}
+	units := []routeUnit{{Name: "ds_0", ConnPool: ds0, Table: "order_1"}}
+	stmt := &gorm.Statement{DB: &gorm.DB{Config: &gorm.Config{}}, Context: context.Background(), Table: "order"}
+	stmt.Logger = logger.Discard
+	if err := r.createTables(stmt, units); err == nil {
+		t.Fatal("want error")
+	}
+	for i := 0; i < 2; i++ {
+		if err := r.createTables(stmt, units); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if len(ds0.sqls) != 1 {
+		t.Errorf("sqls = %q, want created once after retry", ds0.sqls)
+	}
+}
@@ -1,62 +1,216 @@
 package dbroute
 
 import (
+	"fmt"
 	"gorm.io/gorm"
 	"gorm/dbroute/expand"
+	"reflect"
+	"runtime"
 	"strings"
+	"time"
 )
 
 func (dr *DBRoute) registerCallbacks(db *gorm.DB) {
-	dr.Callback().Create().Before("*").Register("gorm:db_route", dr.switchMaster)
+	dr.Callback().Create().Before("*").Register("gorm:db_route", dr.switchCreate)
+	dr.Callback().Create().After("gorm:create").Register("gorm:db_route:insert_id", dr.fillInsertID)
+	dr.Callback().Create().After("gorm:commit_or_rollback_transaction").Register("gorm:db_route:lookup", dr.registerLookupKeys)
 	dr.Callback().Query().Before("*").Register("gorm:db_route", dr.switchSlave)
 	dr.Callback().Update().Before("*").Register("gorm:db_route", dr.switchMaster)
 	dr.Callback().Delete().Before("*").Register("gorm:db_route", dr.switchMaster)
 	dr.Callback().Row().Before("*").Register("gorm:db_route", dr.switchSlave)
 	dr.Callback().Raw().Before("*").Register("gorm:db_route", dr.switchGuess)
+	dr.Callback().Raw().After("gorm:raw").Register("gorm:db_route:lookup", dr.registerLookupKeys)
 }
 
 func (dr *DBRoute) base(db *gorm.DB, op Operation) {
-	expand.ClearWhereTableName(db)
+	if db.DryRun && isSubQueryBuild() {
+		// gorm 以 DryRun 生成子查询，其中的表由外层语句统一路由改写
+		return
+	}
+	if db.Statement.Context.Value(skipRouteKey) != nil {
+		// 已指定数据节点，如迁移
+		return
+	}
+	if err, ok := dr.registerErrors[db.Statement.Table]; ok {
+		db.AddError(err)
+		return
+	}
+	resolveOp, tx := op, isTransaction(db.Statement.ConnPool)
+	if tx {
+		// 事务在开启事务的连接上执行，不切换数据源
+		r := dr.lookupRoute(db.Statement)
+		if r == nil || !r.sharded() {
+			return
+		}
+		if _, ok := db.Statement.ConnPool.(*routeTx); !ok && !r.defaultMasterOnly() {
+			// 未经插件开启的事务（如预编译会话中开启的事务）无法路由到其它数据源，返回错误而非在逻辑表上执行
+			db.AddError(fmt.Errorf("table %s is routed to other data sources and cannot run in a transaction not begun on the default connection of db route", db.Statement.Table))
+			return
+		}
+		resolveOp = Write
+	}
 	expand.PreBuildSql(db)
+	r := dr.lookupRoute(db.Statement)
+	if r == nil {
+		return
+	}
+	if op == Write {
+		// 目录分片中插入的未登记分片键在语句执行成功后登记
+		db.Statement.Context = withLookupRegistrations(db.Statement.Context)
+	}
+	sql := db.Statement.SQL.String()
+	units, plan, err := r.resolve(db.Statement, sql, db.Dialector.Explain(sql, explainVars(db.Statement.Vars)...), resolveOp)
+	if err != nil {
+		db.AddError(err)
+		return
+	}
+	if len(units) == 0 {
+		return
+	}
+	if r.autoCreateTable && op == Write {
+		if err = r.createTables(db.Statement, units); err != nil {
+			db.AddError(err)
+			return
+		}
+	}
 	var newSql strings.Builder
-	newSql.WriteString(dr.routeTb(db.Statement))
+	newSql.WriteString(units[0].Sql)
 	db.Statement.SQL = newSql
-	db.Statement.ConnPool = dr.routeDb(db.Statement, db.Dialector.Explain(db.Statement.SQL.String(), db.Statement.Vars...), op)
+	if tx {
+		// 在事务连接及随事务在其它数据源上开启的事务中依次执行
+		for i := range units {
+			if units[i].ConnPool, err = joinTx(db.Statement.ConnPool, units[i]); err != nil {
+				db.AddError(err)
+				return
+			}
+		}
+		if len(units) > 1 {
+			db.Statement.ConnPool = &shardingConnPool{units: units, plan: plan, sequential: true}
+		} else {
+			db.Statement.ConnPool = units[0].ConnPool
+		}
+		return
+	}
+	if len(units) == 1 {
+		db.Statement.ConnPool = units[0].ConnPool
+		return
+	}
+	db.Statement.ConnPool = &shardingConnPool{units: units, plan: plan, atomic: r.broadcast}
+}
+
+// switchCreate 先生成主键，再构建插入语句并路由
+func (dr *DBRoute) switchCreate(db *gorm.DB) {
+	dr.generateKeys(db)
+	dr.switchMaster(db)
 }
 
 func (dr *DBRoute) switchMaster(db *gorm.DB) {
-	if !isTransaction(db.Statement.ConnPool) {
-		dr.base(db, Write)
-	}
+	dr.base(db, Write)
 }
 
 func (dr *DBRoute) switchSlave(db *gorm.DB) {
-	if !isTransaction(db.Statement.ConnPool) {
-		if rawSQL := db.Statement.SQL.String(); len(rawSQL) > 0 {
-			dr.switchGuess(db)
+	if rawSQL := db.Statement.SQL.String(); len(rawSQL) > 0 {
+		dr.switchGuess(db)
+	} else {
+		_, locking := db.Statement.Clauses["FOR"]
+		if _, ok := db.Statement.Settings.Load(writeName); ok || locking {
+			dr.base(db, Write)
 		} else {
-			_, locking := db.Statement.Clauses["FOR"]
-			if _, ok := db.Statement.Settings.Load(writeName); ok || locking {
-				dr.base(db, Write)
-			} else {
-				dr.base(db, Read)
-			}
+			dr.base(db, Read)
 		}
 	}
 }
 
 func (dr *DBRoute) switchGuess(db *gorm.DB) {
-	if !isTransaction(db.Statement.ConnPool) {
-		if _, ok := db.Statement.Settings.Load(writeName); ok {
-			dr.base(db, Write)
-		} else if rawSQL := strings.TrimSpace(db.Statement.SQL.String()); len(rawSQL) > 10 && strings.EqualFold(rawSQL[:6], "select") && !strings.EqualFold(rawSQL[len(rawSQL)-10:], "for update") {
-			dr.base(db, Read)
-		} else {
-			dr.base(db, Write)
+	if _, ok := db.Statement.Settings.Load(writeName); ok {
+		dr.base(db, Write)
+	} else if rawSQL := strings.TrimSpace(db.Statement.SQL.String()); len(rawSQL) > 10 && strings.EqualFold(rawSQL[:6], "select") && !strings.EqualFold(rawSQL[len(rawSQL)-10:], "for update") {
+		dr.base(db, Read)
+	} else {
+		dr.base(db, Write)
+	}
+}
+
+// fillInsertID
+//
+//	@Description: 多行插入拆分到多个节点执行后，按各节点返回的自增主键回填对应行
+//	@param db
+func (dr *DBRoute) fillInsertID(db *gorm.DB) {
+	pool, ok := db.Statement.ConnPool.(*shardingConnPool)
+	if !ok || db.Error != nil || db.Statement.Schema == nil {
+		return
+	}
+	field := db.Statement.Schema.PrioritizedPrimaryField
+	if kind := db.Statement.ReflectValue.Kind(); field == nil || !field.HasDefaultValue || (kind != reflect.Slice && kind != reflect.Array) {
+		return
+	}
+	for i, unit := range pool.units {
+		if len(unit.Rows) == 0 || i >= len(pool.results) || pool.results[i] == nil {
+			continue
+		}
+		insertID, err := pool.results[i].LastInsertId()
+		if err != nil || insertID <= 0 {
+			db.AddError(err)
+			continue
+		}
+		for _, row := range unit.Rows {
+			rv := db.Statement.ReflectValue.Index(row)
+			if reflect.Indirect(rv).Kind() != reflect.Struct {
+				break
+			}
+			if _, isZero := field.ValueOf(db.Statement.Context, rv); isZero {
+				db.AddError(field.Set(db.Statement.Context, rv, insertID))
+				insertID += field.AutoIncrementIncrement
+			}
 		}
 	}
 }
 
+// subQueryBuilder gorm 生成子查询时在该方法中以 DryRun 执行查询回调
+const subQueryBuilder = "gorm.io/gorm.(*Statement).AddVar"
+
+// isSubQueryBuild 当前回调是否由 gorm 生成子查询触发，用户的 DryRun、ToSQL 仍需路由以输出改写后的语句
+func isSubQueryBuild() bool {
+	pcs := make([]uintptr, 16)
+	frames := runtime.CallersFrames(pcs[:runtime.Callers(3, pcs)])
+	for {
+		frame, more := frames.Next()
+		if frame.Function == subQueryBuilder {
+			return true
+		}
+		if !more {
+			return false
+		}
+	}
+}
+
+// explainVars Explain 输出的时间不带时区，解析分片键前将时间参数转为 UTC 的 RFC3339 字符串，按时间分片时不丢失时区
+func explainVars(vars []interface{}) []interface{} {
+	var converted []interface{}
+	for i, v := range vars {
+		var t time.Time
+		switch tv := v.(type) {
+		case time.Time:
+			t = tv
+		case *time.Time:
+			if tv == nil {
+				continue
+			}
+			t = *tv
+		default:
+			continue
+		}
+		if converted == nil {
+			converted = append([]interface{}(nil), vars...)
+		}
+		converted[i] = t.UTC().Format(time.RFC3339Nano)
+	}
+	if converted == nil {
+		return vars
+	}
+	return converted
+}
+
 func isTransaction(connPool gorm.ConnPool) bool {
 	_, ok := connPool.(gorm.TxCommitter)
 	return ok
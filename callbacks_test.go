@@ -0,0 +1,83 @@
+package dbroute
+
+import (
+	"context"
+	"database/sql"
+	"reflect"
+	"sync"
+	"testing"
+
+	"gorm.io/gorm"
+	"gorm.io/gorm/schema"
+)
+
+type testInsertResult int64
+
+func (r testInsertResult) LastInsertId() (int64, error) {
+	return int64(r), nil
+}
+
+func (r testInsertResult) RowsAffected() (int64, error) {
+	return 0, nil
+}
+
+type testOrder struct {
+	ID     int64
+	UserID int64
+}
+
+func TestFillInsertID(t *testing.T) {
+	tests := []struct {
+		name    string
+		orders  []testOrder
+		units   []routeUnit
+		results []sql.Result
+		want    []int64
+	}{
+		{
+			name:    "ids filled per node in row order",
+			orders:  []testOrder{{UserID: 1}, {UserID: 2}, {UserID: 3}, {UserID: 4}, {UserID: 5}},
+			units:   []routeUnit{{Name: "ds_1", Rows: []int{0, 2, 4}}, {Name: "ds_0", Rows: []int{1, 3}}},
+			results: []sql.Result{testInsertResult(100), testInsertResult(200)},
+			want:    []int64{100, 200, 101, 201, 102},
+		},
+		{
+			name:    "preset ids kept and skipped",
+			orders:  []testOrder{{ID: 7, UserID: 1}, {UserID: 2}, {UserID: 3}},
+			units:   []routeUnit{{Name: "ds_1", Rows: []int{0, 2}}, {Name: "ds_0", Rows: []int{1}}},
+			results: []sql.Result{testInsertResult(100), testInsertResult(200)},
+			want:    []int64{7, 200, 100},
+		},
+		{
+			name:    "unit without result",
+			orders:  []testOrder{{UserID: 1}, {UserID: 2}},
+			units:   []routeUnit{{Name: "ds_1", Rows: []int{0}}, {Name: "ds_0", Rows: []int{1}}},
+			results: []sql.Result{testInsertResult(100), nil},
+			want:    []int64{100, 0},
+		},
+	}
+	s, err := schema.Parse(&testOrder{}, &sync.Map{}, schema.NamingStrategy{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			orders := append([]testOrder(nil), tt.orders...)
+			db := &gorm.DB{Config: &gorm.Config{}, Statement: &gorm.Statement{
+				Context:      context.Background(),
+				Schema:       s,
+				ReflectValue: reflect.ValueOf(&orders).Elem(),
+				ConnPool:     &shardingConnPool{units: tt.units, results: tt.results},
+			}}
+			(&DBRoute{}).fillInsertID(db)
+			if db.Error != nil {
+				t.Fatal(db.Error)
+			}
+			for i, order := range orders {
+				if order.ID != tt.want[i] {
+					t.Errorf("row %d id = %d, want %d", i, order.ID, tt.want[i])
+				}
+			}
+		})
+	}
+}
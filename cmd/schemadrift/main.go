@@ -0,0 +1,101 @@
+// schemadrift 检查分库分表的物理表结构差异
+//
+// 用法：schemadrift -config dbroute.json [-table order] [-v]
+//
+// 配置文件为 JSON，字段对应 config 包中的全局配置：
+//
+//	{
+//	  "orm": {"TablePrefix": "", "SingularTable": true},
+//	  "default": {"DBType": "mysql", "DSN": "..."},
+//	  "datasources": {"default": {"master": {"": {"DBType": "mysql", "DSN": "..."}}}},
+//	  "tables": {"default": ["order"]},
+//	  "rules": [{"table": "order", "actual-tables": ["order_0", "order_1"]}]
+//	}
+//
+// 存在差异时退出码为 1，无法连接或读取时退出码为 2
+package main
+
+import (
+	"encoding/json"
+	"flag"
+	"fmt"
+	"gorm.io/gorm/logger"
+	"gorm/dbroute"
+	"gorm/dbroute/config"
+	"os"
+)
+
+type fileConfig struct {
+	Orm         config.OrmConfig                                 `json:"orm"`
+	Default     config.DBConfig                                  `json:"default"`
+	DataSources map[string]map[string]map[string]config.DBConfig `json:"datasources"`
+	Tables      map[string][]string                              `json:"tables"`
+	Rules       []dbroute.DataShardingRuleModel                  `json:"rules"`
+}
+
+func main() {
+	var (
+		path    = flag.String("config", "dbroute.json", "配置文件")
+		table   = flag.String("table", "", "逻辑表，为空时检查全部已注册的表")
+		verbose = flag.Bool("v", false, "打印执行的sql")
+	)
+	flag.Parse()
+
+	if err := load(*path); err != nil {
+		exit(err)
+	}
+	db, err := config.NewOrmDB()
+	if err != nil {
+		exit(err)
+	}
+	if !*verbose {
+		db.Logger = logger.Discard
+	}
+	dbRoute, ok := db.Config.Plugins[(&dbroute.DBRoute{}).Name()].(*dbroute.DBRoute)
+	if !ok {
+		exit(fmt.Errorf("db route plugin is not registered"))
+	}
+
+	var reports []*dbroute.SchemaReport
+	if *table != "" {
+		report, err := dbRoute.CheckSchema(*table)
+		if err != nil {
+			exit(err)
+		}
+		reports = append(reports, report)
+	} else if reports, err = dbRoute.CheckSchemas(); err != nil {
+		exit(err)
+	}
+
+	drifted := false
+	for _, report := range reports {
+		fmt.Print(report)
+		drifted = drifted || len(report.Drifts) > 0
+	}
+	if drifted {
+		os.Exit(1)
+	}
+}
+
+// load 读取配置文件到 config 包的全局配置
+func load(path string) error {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return err
+	}
+	var c fileConfig
+	if err = json.Unmarshal(data, &c); err != nil {
+		return fmt.Errorf("parse %s: %w", path, err)
+	}
+	*config.C = c.Orm
+	config.DbDefaultConfig = c.Default
+	config.DbMultiConfig = c.DataSources
+	config.DbTableAttributeMap = c.Tables
+	config.DataShardingRuleModelModels = c.Rules
+	return nil
+}
+
+func exit(err error) {
+	fmt.Fprintln(os.Stderr, err)
+	os.Exit(2)
+}
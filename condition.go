@@ -0,0 +1,432 @@
+package dbroute
+
+import (
+	"fmt"
+	"github.com/xwb1989/sqlparser"
+	"math"
+	"sort"
+)
+
+// maxRangeEnumeration 整数范围逐个计算分片的最大跨度，超出时路由到全部分片
+const maxRangeEnumeration = 1024
+
+// ShardingRange 分片键取值范围，Lower、Upper 为 nil 表示无界
+type ShardingRange struct {
+	Lower          interface{}
+	Upper          interface{}
+	LowerInclusive bool
+	UpperInclusive bool
+}
+
+// ShardingCondition 分片键条件，Values 与 Ranges 取并集，All 表示无法确定范围需路由到全部分片
+type ShardingCondition struct {
+	All    bool
+	Values []interface{}
+	Ranges []ShardingRange
+}
+
+// ShardingConditions 多个分片键各自的条件，按列名索引
+type ShardingConditions map[string]ShardingCondition
+
+// GetSqlShardingConditions 从sql的条件中分别解析多个分片键的取值集合与范围，解析失败时返回错误
+func GetSqlShardingConditions(sql string, keys ...string) (ShardingConditions, error) {
+	stmt, err := sqlparser.Parse(sql)
+	if err != nil {
+		return nil, fmt.Errorf("parse sql err: %w", err)
+	}
+	conds := make(ShardingConditions, len(keys))
+	for _, key := range keys {
+		conds[key] = getSqlShardingCondition(stmt, key)
+	}
+	return conds, nil
+}
+
+// GetSqlShardingCondition 从sql的条件中解析分片键的取值集合与范围，解析失败时返回错误
+func GetSqlShardingCondition(sql string, key string) (ShardingCondition, error) {
+	stmt, err := sqlparser.Parse(sql)
+	if err != nil {
+		return ShardingCondition{}, fmt.Errorf("parse sql err: %w", err)
+	}
+	return getSqlShardingCondition(stmt, key), nil
+}
+
+func getSqlShardingCondition(stmt sqlparser.Statement, key string) ShardingCondition {
+	var where *sqlparser.Where
+	var from sqlparser.TableExprs
+	switch node := stmt.(type) {
+	case *sqlparser.Select:
+		where, from = node.Where, node.From
+	case *sqlparser.Update:
+		where, from = node.Where, node.TableExprs
+	case *sqlparser.Delete:
+		where, from = node.Where, node.TableExprs
+	case *sqlparser.Insert:
+		return insertCondition(node, key)
+	}
+	if where == nil || key == "" {
+		return ShardingCondition{All: true}
+	}
+	return analyzeCondition(where.Expr, shardingColumn{name: key, qualifiers: mainTableQualifiers(from)})
+}
+
+// shardingColumn 分片列，条件中的列未限定表名，或以主表的表名、别名限定时为分片列，
+// 以关联表限定的同名列（如 JOIN 中 u.user_id）不是分片列
+type shardingColumn struct {
+	name       string
+	qualifiers map[string]bool
+}
+
+// mainTableQualifiers 主表（FROM 中最左侧的表）可用于限定列的表名及别名
+func mainTableQualifiers(from sqlparser.TableExprs) map[string]bool {
+	qualifiers := make(map[string]bool)
+	if len(from) == 0 {
+		return qualifiers
+	}
+	expr := from[0]
+	for {
+		switch table := expr.(type) {
+		case *sqlparser.JoinTableExpr:
+			expr = table.LeftExpr
+			continue
+		case *sqlparser.ParenTableExpr:
+			if len(table.Exprs) == 0 {
+				return qualifiers
+			}
+			expr = table.Exprs[0]
+			continue
+		case *sqlparser.AliasedTableExpr:
+			if name, ok := table.Expr.(sqlparser.TableName); ok {
+				qualifiers[name.Name.String()] = true
+			}
+			if !table.As.IsEmpty() {
+				qualifiers[table.As.String()] = true
+			}
+		}
+		return qualifiers
+	}
+}
+
+// insertCondition 插入语句各行分片键的取值
+func insertCondition(node *sqlparser.Insert, key string) ShardingCondition {
+	rows, ok := node.Rows.(sqlparser.Values)
+	index := -1
+	for i, column := range node.Columns {
+		if column.CompliantName() == key {
+			index = i
+		}
+	}
+	if !ok || index < 0 || key == "" {
+		return ShardingCondition{All: true}
+	}
+	var cond ShardingCondition
+	for _, row := range rows {
+		if index >= len(row) {
+			return ShardingCondition{All: true}
+		}
+		value, ok := literalValue(row[index])
+		if !ok {
+			return ShardingCondition{All: true}
+		}
+		cond.Values = appendValue(cond.Values, value)
+	}
+	return cond
+}
+
+// analyzeCondition 遍历条件树：AND 取交集，OR 取并集，无法识别的条件视为不限制
+func analyzeCondition(expr sqlparser.Expr, key shardingColumn) ShardingCondition {
+	switch node := expr.(type) {
+	case *sqlparser.AndExpr:
+		return analyzeCondition(node.Left, key).and(analyzeCondition(node.Right, key))
+	case *sqlparser.OrExpr:
+		return analyzeCondition(node.Left, key).or(analyzeCondition(node.Right, key))
+	case *sqlparser.ParenExpr:
+		return analyzeCondition(node.Expr, key)
+	case *sqlparser.ComparisonExpr:
+		return analyzeComparison(node, key)
+	case *sqlparser.RangeCond:
+		if node.Operator == sqlparser.BetweenStr && isShardingColumn(node.Left, key) {
+			from, ok := literalValue(node.From)
+			to, ok2 := literalValue(node.To)
+			if ok && ok2 {
+				return ShardingCondition{Ranges: []ShardingRange{{Lower: from, Upper: to, LowerInclusive: true, UpperInclusive: true}}}
+			}
+		}
+	}
+	return ShardingCondition{All: true}
+}
+
+func analyzeComparison(node *sqlparser.ComparisonExpr, key shardingColumn) ShardingCondition {
+	left, right, operator := node.Left, node.Right, node.Operator
+	if !isShardingColumn(left, key) && isShardingColumn(right, key) {
+		// 常量在左侧时交换比较方向
+		left, right = right, left
+		switch operator {
+		case sqlparser.LessThanStr:
+			operator = sqlparser.GreaterThanStr
+		case sqlparser.LessEqualStr:
+			operator = sqlparser.GreaterEqualStr
+		case sqlparser.GreaterThanStr:
+			operator = sqlparser.LessThanStr
+		case sqlparser.GreaterEqualStr:
+			operator = sqlparser.LessEqualStr
+		}
+	}
+	if !isShardingColumn(left, key) {
+		return ShardingCondition{All: true}
+	}
+	if operator == sqlparser.InStr {
+		tuple, ok := right.(sqlparser.ValTuple)
+		if !ok {
+			return ShardingCondition{All: true}
+		}
+		var cond ShardingCondition
+		for _, expr := range tuple {
+			value, ok := literalValue(expr)
+			if !ok {
+				return ShardingCondition{All: true}
+			}
+			cond.Values = appendValue(cond.Values, value)
+		}
+		return cond
+	}
+	value, ok := literalValue(right)
+	if !ok {
+		return ShardingCondition{All: true}
+	}
+	switch operator {
+	case sqlparser.EqualStr, sqlparser.NullSafeEqualStr:
+		return ShardingCondition{Values: []interface{}{value}}
+	case sqlparser.LessThanStr:
+		return ShardingCondition{Ranges: []ShardingRange{{Upper: value}}}
+	case sqlparser.LessEqualStr:
+		return ShardingCondition{Ranges: []ShardingRange{{Upper: value, UpperInclusive: true}}}
+	case sqlparser.GreaterThanStr:
+		return ShardingCondition{Ranges: []ShardingRange{{Lower: value}}}
+	case sqlparser.GreaterEqualStr:
+		return ShardingCondition{Ranges: []ShardingRange{{Lower: value, LowerInclusive: true}}}
+	}
+	return ShardingCondition{All: true}
+}
+
+func isShardingColumn(expr sqlparser.Expr, key shardingColumn) bool {
+	name, ok := expr.(*sqlparser.ColName)
+	if !ok || name.Name.CompliantName() != key.name {
+		return false
+	}
+	return name.Qualifier.IsEmpty() || key.qualifiers[name.Qualifier.Name.String()]
+}
+
+// literalValue 常量的值，字符串为 string，整数为 int64，小数为 float64
+func literalValue(expr sqlparser.Expr) (interface{}, bool) {
+	val, ok := expr.(*sqlparser.SQLVal)
+	if !ok || val.Type == sqlparser.ValArg {
+		return nil, false
+	}
+	value, err := sqlValue(val, nil)
+	return value, err == nil
+}
+
+func appendValue(values []interface{}, value interface{}) []interface{} {
+	for _, v := range values {
+		if compareValues(v, value) == 0 {
+			return values
+		}
+	}
+	return append(values, value)
+}
+
+// and 条件交集
+func (c ShardingCondition) and(o ShardingCondition) ShardingCondition {
+	if c.All {
+		return o
+	}
+	if o.All {
+		return c
+	}
+	var result ShardingCondition
+	for _, value := range c.Values {
+		if o.contains(value) {
+			result.Values = appendValue(result.Values, value)
+		}
+	}
+	for _, value := range o.Values {
+		if c.contains(value) {
+			result.Values = appendValue(result.Values, value)
+		}
+	}
+	for _, a := range c.Ranges {
+		for _, b := range o.Ranges {
+			if r, ok := a.intersect(b); ok {
+				result.Ranges = append(result.Ranges, r)
+			}
+		}
+	}
+	if len(result.Values) == 0 && len(result.Ranges) == 0 {
+		// 条件恒为假，交由全部分片执行以保持查询语义
+		return ShardingCondition{All: true}
+	}
+	return result
+}
+
+// or 条件并集
+func (c ShardingCondition) or(o ShardingCondition) ShardingCondition {
+	if c.All || o.All {
+		return ShardingCondition{All: true}
+	}
+	result := ShardingCondition{Ranges: append(append([]ShardingRange{}, c.Ranges...), o.Ranges...)}
+	for _, value := range append(append([]interface{}{}, c.Values...), o.Values...) {
+		result.Values = appendValue(result.Values, value)
+	}
+	return result
+}
+
+func (c ShardingCondition) contains(value interface{}) bool {
+	for _, v := range c.Values {
+		if compareValues(v, value) == 0 {
+			return true
+		}
+	}
+	for _, r := range c.Ranges {
+		if r.contains(value) {
+			return true
+		}
+	}
+	return false
+}
+
+// values 条件命中的全部取值，范围逐个枚举，无法确定时返回 false
+func (c ShardingCondition) values() ([]interface{}, bool) {
+	if c.All {
+		return nil, false
+	}
+	values := append([]interface{}{}, c.Values...)
+	for _, r := range c.Ranges {
+		enumerated, ok := r.enumerate()
+		if !ok {
+			return nil, false
+		}
+		for _, value := range enumerated {
+			values = appendValue(values, value)
+		}
+	}
+	return values, len(values) > 0
+}
+
+// targets 按各分片键取值的全部组合计算命中的分片并排序去重，任一分片键无法确定或组合过多时返回 false，无法计算分片时返回错误
+func (c ShardingConditions) targets(sharding shardingFunc) ([]string, bool, error) {
+	if len(c) == 0 {
+		return nil, false, nil
+	}
+	combinations := []map[string]interface{}{{}}
+	for key, cond := range c {
+		values, ok := cond.values()
+		if !ok || len(combinations)*len(values) > maxRangeEnumeration {
+			return nil, false, nil
+		}
+		next := make([]map[string]interface{}, 0, len(combinations)*len(values))
+		for _, combination := range combinations {
+			for _, value := range values {
+				parameters := make(map[string]interface{}, len(combination)+1)
+				for k, v := range combination {
+					parameters[k] = v
+				}
+				parameters[key] = value
+				next = append(next, parameters)
+			}
+		}
+		combinations = next
+	}
+	seen := make(map[string]bool)
+	var targets []string
+	for _, parameters := range combinations {
+		target, err := sharding(parameters)
+		if err != nil {
+			return nil, false, err
+		}
+		if !seen[target] {
+			seen[target] = true
+			targets = append(targets, target)
+		}
+	}
+	sort.Strings(targets)
+	return targets, true, nil
+}
+
+func (r ShardingRange) contains(value interface{}) bool {
+	if r.Lower != nil {
+		if c := compareValues(value, r.Lower); c < 0 || (c == 0 && !r.LowerInclusive) {
+			return false
+		}
+	}
+	if r.Upper != nil {
+		if c := compareValues(value, r.Upper); c > 0 || (c == 0 && !r.UpperInclusive) {
+			return false
+		}
+	}
+	return true
+}
+
+// intersect 范围交集，为空时返回 false
+func (r ShardingRange) intersect(o ShardingRange) (ShardingRange, bool) {
+	result := r
+	if o.Lower != nil {
+		if result.Lower == nil {
+			result.Lower, result.LowerInclusive = o.Lower, o.LowerInclusive
+		} else if c := compareValues(o.Lower, result.Lower); c > 0 {
+			result.Lower, result.LowerInclusive = o.Lower, o.LowerInclusive
+		} else if c == 0 {
+			result.LowerInclusive = result.LowerInclusive && o.LowerInclusive
+		}
+	}
+	if o.Upper != nil {
+		if result.Upper == nil {
+			result.Upper, result.UpperInclusive = o.Upper, o.UpperInclusive
+		} else if c := compareValues(o.Upper, result.Upper); c < 0 {
+			result.Upper, result.UpperInclusive = o.Upper, o.UpperInclusive
+		} else if c == 0 {
+			result.UpperInclusive = result.UpperInclusive && o.UpperInclusive
+		}
+	}
+	if result.Lower != nil && result.Upper != nil {
+		if c := compareValues(result.Lower, result.Upper); c > 0 || (c == 0 && !(result.LowerInclusive && result.UpperInclusive)) {
+			return result, false
+		}
+	}
+	return result, true
+}
+
+// enumerate 有界整数范围内的全部取值，跨度过大或非整数时返回 false
+func (r ShardingRange) enumerate() ([]interface{}, bool) {
+	lower, ok := r.Lower.(int64)
+	upper, ok2 := r.Upper.(int64)
+	if !ok || !ok2 {
+		return nil, false
+	}
+	if !r.LowerInclusive {
+		if lower == math.MaxInt64 {
+			return nil, true
+		}
+		lower++
+	}
+	if !r.UpperInclusive {
+		if upper == math.MinInt64 {
+			return nil, true
+		}
+		upper--
+	}
+	if upper < lower {
+		return nil, true
+	}
+	// 跨度按无符号数比较，避免首尾为 int64 边界时溢出
+	if uint64(upper-lower) >= maxRangeEnumeration {
+		return nil, false
+	}
+	values := make([]interface{}, 0, upper-lower+1)
+	for v := lower; ; v++ {
+		values = append(values, v)
+		if v == upper {
+			break
+		}
+	}
+	return values, true
+}
@@ -0,0 +1,240 @@
+package dbroute
+
+import (
+	"errors"
+	"fmt"
+	"math"
+	"reflect"
+	"testing"
+)
+
+func TestAnalyzeCondition(t *testing.T) {
+	tests := []struct {
+		name string
+		sql  string
+		want ShardingCondition
+	}{
+		{
+			name: "equal",
+			sql:  "SELECT * FROM t WHERE user_id = 3",
+			want: ShardingCondition{Values: []interface{}{int64(3)}},
+		},
+		{
+			name: "in deduplicated",
+			sql:  "SELECT * FROM t WHERE user_id IN (1, 2, 2)",
+			want: ShardingCondition{Values: []interface{}{int64(1), int64(2)}},
+		},
+		{
+			name: "between",
+			sql:  "SELECT * FROM t WHERE user_id BETWEEN 1 AND 3",
+			want: ShardingCondition{Ranges: []ShardingRange{{Lower: int64(1), Upper: int64(3), LowerInclusive: true, UpperInclusive: true}}},
+		},
+		{
+			name: "and of ranges intersected",
+			sql:  "SELECT * FROM t WHERE user_id > 1 AND user_id <= 3",
+			want: ShardingCondition{Ranges: []ShardingRange{{Lower: int64(1), Upper: int64(3), UpperInclusive: true}}},
+		},
+		{
+			name: "constant on the left",
+			sql:  "SELECT * FROM t WHERE 5 > user_id",
+			want: ShardingCondition{Ranges: []ShardingRange{{Upper: int64(5)}}},
+		},
+		{
+			name: "or of values united",
+			sql:  "SELECT * FROM t WHERE user_id = 1 OR user_id = 4",
+			want: ShardingCondition{Values: []interface{}{int64(1), int64(4)}, Ranges: []ShardingRange{}},
+		},
+		{
+			name: "or with other column",
+			sql:  "SELECT * FROM t WHERE user_id = 1 OR name = 'a'",
+			want: ShardingCondition{All: true},
+		},
+		{
+			name: "and of in and equal",
+			sql:  "SELECT * FROM t WHERE user_id IN (1, 2) AND user_id = 2",
+			want: ShardingCondition{Values: []interface{}{int64(2)}},
+		},
+		{
+			name: "contradiction",
+			sql:  "SELECT * FROM t WHERE user_id = 1 AND user_id = 2",
+			want: ShardingCondition{All: true},
+		},
+		{
+			name: "other column",
+			sql:  "SELECT * FROM t WHERE name = 'a'",
+			want: ShardingCondition{All: true},
+		},
+		{
+			name: "no where",
+			sql:  "SELECT * FROM t",
+			want: ShardingCondition{All: true},
+		},
+		{
+			name: "qualified by table name",
+			sql:  "SELECT * FROM t WHERE t.user_id = 'x'",
+			want: ShardingCondition{Values: []interface{}{"x"}},
+		},
+		{
+			name: "qualified by alias of main table",
+			sql:  "SELECT * FROM t AS o JOIN u ON o.id = u.oid WHERE o.user_id = 1",
+			want: ShardingCondition{Values: []interface{}{int64(1)}},
+		},
+		{
+			name: "qualified by joined table",
+			sql:  "SELECT * FROM t AS o JOIN u ON o.id = u.oid WHERE u.user_id = 1",
+			want: ShardingCondition{All: true},
+		},
+		{
+			name: "insert rows",
+			sql:  "INSERT INTO t (id, user_id) VALUES (1, 7), (2, 8)",
+			want: ShardingCondition{Values: []interface{}{int64(7), int64(8)}},
+		},
+		{
+			name: "update",
+			sql:  "UPDATE t SET a = 1 WHERE user_id = 9",
+			want: ShardingCondition{Values: []interface{}{int64(9)}},
+		},
+		{
+			name: "delete",
+			sql:  "DELETE FROM t WHERE user_id IN (3, 4)",
+			want: ShardingCondition{Values: []interface{}{int64(3), int64(4)}},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, err := GetSqlShardingCondition(tt.sql, "user_id")
+			if err != nil {
+				t.Fatal(err)
+			}
+			if !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("condition = %#v, want %#v", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestGetSqlShardingConditionParseError(t *testing.T) {
+	if _, err := GetSqlShardingCondition("SELEKT * FROM t", "user_id"); err == nil {
+		t.Error("want parse error")
+	}
+	if _, err := GetSqlShardingConditions("SELEKT * FROM t", "user_id"); err == nil {
+		t.Error("want parse error")
+	}
+}
+
+func TestShardingConditionsTargets(t *testing.T) {
+	modTable := func(values map[string]interface{}) (string, error) {
+		v, err := toInt64(values["user_id"])
+		if err != nil {
+			return "", err
+		}
+		return fmt.Sprintf("t_%d", v%4), nil
+	}
+	tenantTable := func(values map[string]interface{}) (string, error) {
+		return fmt.Sprintf("t_%v_%v", values["tenant_id"], values["user_id"]), nil
+	}
+	tests := []struct {
+		name     string
+		conds    ShardingConditions
+		sharding shardingFunc
+		want     []string
+		wantOk   bool
+		wantErr  bool
+	}{
+		{
+			name:     "values sorted and deduplicated",
+			conds:    ShardingConditions{"user_id": {Values: []interface{}{int64(5), int64(1), int64(2)}}},
+			sharding: modTable,
+			want:     []string{"t_1", "t_2"},
+			wantOk:   true,
+		},
+		{
+			name:     "range enumerated",
+			conds:    ShardingConditions{"user_id": {Ranges: []ShardingRange{{Lower: int64(1), Upper: int64(3), LowerInclusive: true}}}},
+			sharding: modTable,
+			want:     []string{"t_1", "t_2"},
+			wantOk:   true,
+		},
+		{
+			name:     "unbounded range",
+			conds:    ShardingConditions{"user_id": {Ranges: []ShardingRange{{Lower: int64(1)}}}},
+			sharding: modTable,
+		},
+		{
+			name:     "range too wide",
+			conds:    ShardingConditions{"user_id": {Ranges: []ShardingRange{{Lower: int64(0), Upper: int64(maxRangeEnumeration), LowerInclusive: true, UpperInclusive: true}}}},
+			sharding: modTable,
+		},
+		{
+			name:     "range at int64 upper bound",
+			conds:    ShardingConditions{"user_id": {Ranges: []ShardingRange{{Lower: int64(math.MaxInt64 - 2), Upper: int64(math.MaxInt64), LowerInclusive: true, UpperInclusive: true}}}},
+			sharding: modTable,
+			want:     []string{"t_1", "t_2", "t_3"},
+			wantOk:   true,
+		},
+		{
+			name:     "range at int64 lower bound",
+			conds:    ShardingConditions{"user_id": {Ranges: []ShardingRange{{Lower: int64(math.MinInt64), Upper: int64(math.MinInt64 + 2), LowerInclusive: true, UpperInclusive: true}}}},
+			sharding: modTable,
+			want:     []string{"t_-2", "t_-3", "t_0"},
+			wantOk:   true,
+		},
+		{
+			name:     "exclusive lower at int64 upper bound",
+			conds:    ShardingConditions{"user_id": {Ranges: []ShardingRange{{Lower: int64(math.MaxInt64), Upper: int64(math.MaxInt64), UpperInclusive: true}}}},
+			sharding: modTable,
+		},
+		{
+			name:     "exclusive upper at int64 lower bound",
+			conds:    ShardingConditions{"user_id": {Ranges: []ShardingRange{{Lower: int64(math.MinInt64), Upper: int64(math.MinInt64), LowerInclusive: true}}}},
+			sharding: modTable,
+		},
+		{
+			name:     "range spans int64",
+			conds:    ShardingConditions{"user_id": {Ranges: []ShardingRange{{Lower: int64(math.MinInt64), Upper: int64(math.MaxInt64), LowerInclusive: true, UpperInclusive: true}}}},
+			sharding: modTable,
+		},
+		{
+			name:     "all",
+			conds:    ShardingConditions{"user_id": {All: true}},
+			sharding: modTable,
+		},
+		{
+			name: "multiple columns combined",
+			conds: ShardingConditions{
+				"tenant_id": {Values: []interface{}{"a", "b"}},
+				"user_id":   {Values: []interface{}{int64(1)}},
+			},
+			sharding: tenantTable,
+			want:     []string{"t_a_1", "t_b_1"},
+			wantOk:   true,
+		},
+		{
+			name: "one column undetermined",
+			conds: ShardingConditions{
+				"tenant_id": {Values: []interface{}{"a"}},
+				"user_id":   {All: true},
+			},
+			sharding: tenantTable,
+		},
+		{
+			name:  "sharding error",
+			conds: ShardingConditions{"user_id": {Values: []interface{}{int64(1)}}},
+			sharding: func(map[string]interface{}) (string, error) {
+				return "", errors.New("boom")
+			},
+			wantErr: true,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, ok, err := tt.conds.targets(tt.sharding)
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("err = %v, wantErr %v", err, tt.wantErr)
+			}
+			if ok != tt.wantOk || !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("targets = %v, %v, want %v, %v", got, ok, tt.want, tt.wantOk)
+			}
+		})
+	}
+}
@@ -56,15 +56,13 @@ func NewOrmDB() (*gorm.DB, error) {
 	cfgMap := DbMultiConfig
 
 	if _, no := cfgMap[DefaultDatabaseName]; !no {
-		fmt.Errorf("default db not exist")
-		return nil, nil
+		return nil, fmt.Errorf("default db not exist")
 	}
 	// use default config to build db instance
 	defaultDialector := openDialector(DbDefaultConfig)
 	db, err := gorm.Open(defaultDialector, defaultConfig(ormConfig))
 	if err != nil {
-		fmt.Errorf("open default dialector errors")
-		return nil, err
+		return nil, fmt.Errorf("open default dialector errors: %w", err)
 	}
 	if ormConfig.Debug {
 		db.Debug()
@@ -109,7 +107,9 @@ func NewOrmDB() (*gorm.DB, error) {
 			TraceRouteMode: true,
 		}, DbTableAttributeMap[dataSourceName]...)
 	}
-	db.Use(&dbRoute)
+	if err = db.Use(&dbRoute); err != nil {
+		return nil, fmt.Errorf("use db route plugin: %w", err)
+	}
 	//db.Use(prometheus.New(prometheus.Config{
 	//	DBName:          "sl",                     // 使用 `DBName` 作为指标 label
 	//	RefreshInterval: 15,                       // 指标刷新频率（默认为 15 秒）
@@ -0,0 +1,205 @@
+package dbroute
+
+import (
+	"context"
+	"gorm.io/gorm"
+	"gorm.io/gorm/logger"
+	"hash/crc32"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+)
+
+// DefaultVirtualNodes 每个数据源默认的虚拟节点数
+const DefaultVirtualNodes = 160
+
+// HashRing 一致性哈希环，每个数据源按权重放置若干虚拟节点
+type HashRing struct {
+	points []uint32
+	owners []ShardingName
+}
+
+// HashRange 哈希环上的区间 (Start, End]，Start >= End 时跨越环的起点
+type HashRange struct {
+	Start uint32
+	End   uint32
+	From  ShardingName
+	To    ShardingName
+}
+
+// NewHashRing
+//
+//	@Description: 创建一致性哈希环
+//	@param names	数据源
+//	@param virtualNodes	每个数据源的虚拟节点数，小于等于 0 时使用 DefaultVirtualNodes
+//	@param weights	数据源权重，虚拟节点数按权重倍增，未配置时为 1
+//	@return *HashRing
+func NewHashRing(names []ShardingName, virtualNodes int, weights map[ShardingName]int) *HashRing {
+	if virtualNodes <= 0 {
+		virtualNodes = DefaultVirtualNodes
+	}
+	type point struct {
+		hash  uint32
+		owner ShardingName
+	}
+	var points []point
+	for _, name := range names {
+		weight := weights[name]
+		if weight <= 0 {
+			weight = 1
+		}
+		for i := 0; i < virtualNodes*weight; i++ {
+			points = append(points, point{hash: hashKey(string(name) + "#" + strconv.Itoa(i)), owner: name})
+		}
+	}
+	// 哈希冲突时按名称决定归属，保证与数据源顺序无关
+	sort.Slice(points, func(i, j int) bool {
+		if points[i].hash != points[j].hash {
+			return points[i].hash < points[j].hash
+		}
+		return points[i].owner < points[j].owner
+	})
+	ring := &HashRing{}
+	for i, p := range points {
+		if i > 0 && p.hash == points[i-1].hash {
+			continue
+		}
+		ring.points = append(ring.points, p.hash)
+		ring.owners = append(ring.owners, p.owner)
+	}
+	return ring
+}
+
+func hashKey(key string) uint32 {
+	return crc32.ChecksumIEEE([]byte(key))
+}
+
+// Locate 分片键所属的数据源
+func (r *HashRing) Locate(key string) ShardingName {
+	return r.owner(hashKey(key))
+}
+
+// owner 哈希值所属的数据源，为顺时针方向第一个虚拟节点
+func (r *HashRing) owner(hash uint32) ShardingName {
+	if len(r.points) == 0 {
+		return ""
+	}
+	i := sort.Search(len(r.points), func(i int) bool { return r.points[i] >= hash })
+	if i == len(r.points) {
+		i = 0
+	}
+	return r.owners[i]
+}
+
+// MovedRanges
+//
+//	@Description: 比较增删数据源前后的哈希环，返回归属发生变化的哈希区间及迁移方向，相邻且方向相同的区间合并
+//	@param before
+//	@param after
+//	@return []HashRange
+func MovedRanges(before, after *HashRing) []HashRange {
+	seen := make(map[uint32]bool)
+	var points []uint32
+	for _, p := range append(append([]uint32{}, before.points...), after.points...) {
+		if !seen[p] {
+			seen[p] = true
+			points = append(points, p)
+		}
+	}
+	sort.Slice(points, func(i, j int) bool { return points[i] < points[j] })
+	var moved []HashRange
+	for i, end := range points {
+		start := points[(i+len(points)-1)%len(points)]
+		from, to := before.owner(end), after.owner(end)
+		if from == to {
+			continue
+		}
+		if n := len(moved); n > 0 && moved[n-1].End == start && moved[n-1].From == from && moved[n-1].To == to {
+			moved[n-1].End = end
+			continue
+		}
+		moved = append(moved, HashRange{Start: start, End: end, From: from, To: to})
+	}
+	// 首尾区间在环的起点处相接时合并
+	if n := len(moved); n > 1 && moved[n-1].End == moved[0].Start && moved[n-1].From == moved[0].From && moved[n-1].To == moved[0].To {
+		moved[0].Start = moved[n-1].Start
+		moved = moved[:n-1]
+	}
+	return moved
+}
+
+// DbConsistentHashPolicy 一致性哈希分库，增删数据源时只迁移相邻区间的数据
+type DbConsistentHashPolicy struct {
+	// 需要操作分库分表，使用其中的分库键
+	DataShardingRuleModelMap map[string]DataShardingRuleModel
+	// VirtualNodes 每个数据源的虚拟节点数，默认 DefaultVirtualNodes
+	VirtualNodes int
+	// Weights 数据源权重，默认 1
+	Weights map[ShardingName]int
+	// 按数据源集合缓存的哈希环
+	rings sync.Map
+}
+
+// Ring 数据源集合对应的哈希环
+func (p *DbConsistentHashPolicy) Ring(names ...ShardingName) *HashRing {
+	sorted := append([]ShardingName{}, names...)
+	sort.Slice(sorted, func(i, j int) bool { return sorted[i] < sorted[j] })
+	keys := make([]string, len(sorted))
+	for i, name := range sorted {
+		keys[i] = string(name)
+	}
+	key := strings.Join(keys, ",")
+	if ring, ok := p.rings.Load(key); ok {
+		return ring.(*HashRing)
+	}
+	ring, _ := p.rings.LoadOrStore(key, NewHashRing(sorted, p.VirtualNodes, p.Weights))
+	return ring.(*HashRing)
+}
+
+func (p *DbConsistentHashPolicy) validate() error {
+	return validateRules(p.DataShardingRuleModelMap)
+}
+
+// Resolve
+//
+//	@Description: 按分库键取值在哈希环上定位数据源，多列分库键按配置顺序拼接为哈希键，sql为填充了参数值的sql
+func (p *DbConsistentHashPolicy) Resolve(ctx context.Context, connPoolsMap map[ShardingName][]gorm.ConnPool, tableName string, sql string, log logger.Interface) (result DbPolicyResult) {
+	model, ok := p.DataShardingRuleModelMap[tableName]
+	parameters := model.databaseShardingParameters()
+	if !ok || len(parameters) == 0 {
+		// 不存在，走随机路由
+		return DbRandomPolicy{}.Resolve(ctx, connPoolsMap, tableName, sql, log)
+	}
+	names := make([]ShardingName, 0, len(connPoolsMap))
+	for name := range connPoolsMap {
+		names = append(names, name)
+	}
+	ring := p.Ring(names...)
+	conds, err := GetSqlShardingConditions(sql, parameters...)
+	if err != nil {
+		return DbPolicyResult{Error: err}
+	}
+	targets, ok, _ := conds.targets(func(values map[string]interface{}) (string, error) {
+		keys := make([]string, len(parameters))
+		for i, parameter := range parameters {
+			keys[i] = toString(values[parameter])
+		}
+		return string(ring.Locate(strings.Join(keys, ","))), nil
+	})
+	if !ok {
+		// 无法确定分库，分散到全部数据源
+		result = scatterPolicyResult(connPoolsMap)
+		log.Info(ctx, "database scatter: %v", len(result.Scatter))
+		return result
+	}
+	log.Info(ctx, "database sharding: %v", targets)
+	if len(targets) > 1 {
+		selected := make([]ShardingName, len(targets))
+		for i, target := range targets {
+			selected[i] = ShardingName(target)
+		}
+		return scatterPolicyResult(connPoolsMap, selected...)
+	}
+	return randomConnPool(ShardingName(targets[0]), connPoolsMap[ShardingName(targets[0])])
+}
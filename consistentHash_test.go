@@ -0,0 +1,143 @@
+package dbroute
+
+import (
+	"context"
+	"fmt"
+	"testing"
+
+	"gorm.io/gorm"
+	"gorm.io/gorm/logger"
+)
+
+// inHashRange 哈希值是否位于区间 (Start, End]，Start >= End 时跨越环的起点
+func inHashRange(hash uint32, r HashRange) bool {
+	if r.Start < r.End {
+		return hash > r.Start && hash <= r.End
+	}
+	return hash > r.Start || hash <= r.End
+}
+
+func TestHashRingWeights(t *testing.T) {
+	ring := NewHashRing([]ShardingName{"ds_0", "ds_1"}, 100, map[ShardingName]int{"ds_1": 3})
+	points := make(map[ShardingName]int)
+	for _, owner := range ring.owners {
+		points[owner]++
+	}
+	if points["ds_0"] != 100 || points["ds_1"] != 300 {
+		t.Errorf("virtual nodes = %v, want ds_0 100, ds_1 300", points)
+	}
+	keys := make(map[ShardingName]int)
+	for i := 0; i < 10000; i++ {
+		keys[ring.Locate(fmt.Sprint(i))]++
+	}
+	if share := float64(keys["ds_1"]) / 10000; share < 0.65 || share > 0.85 {
+		t.Errorf("ds_1 share = %.2f, want about 0.75", share)
+	}
+}
+
+func TestHashRingLocate(t *testing.T) {
+	ring := NewHashRing([]ShardingName{"ds_0", "ds_1", "ds_2"}, 0, nil)
+	if len(ring.points) != 3*DefaultVirtualNodes {
+		t.Errorf("points = %d, want %d", len(ring.points), 3*DefaultVirtualNodes)
+	}
+	reordered := NewHashRing([]ShardingName{"ds_2", "ds_0", "ds_1"}, 0, nil)
+	for i := 0; i < 1000; i++ {
+		key := fmt.Sprint(i)
+		got := ring.Locate(key)
+		if again := ring.Locate(key); again != got {
+			t.Fatalf("Locate(%s) = %s, then %s", key, got, again)
+		}
+		if other := reordered.Locate(key); other != got {
+			t.Fatalf("Locate(%s) = %s, reordered ring %s", key, got, other)
+		}
+		// 顺时针方向第一个不小于哈希值的虚拟节点，超过最大值时回到环的起点
+		hash, want := hashKey(key), ring.owners[0]
+		for j, point := range ring.points {
+			if point >= hash {
+				want = ring.owners[j]
+				break
+			}
+		}
+		if got != want {
+			t.Fatalf("Locate(%s) = %s, want %s", key, got, want)
+		}
+	}
+	if got := NewHashRing(nil, 0, nil).Locate("1"); got != "" {
+		t.Errorf("Locate() on empty ring = %s, want empty", got)
+	}
+}
+
+func TestMovedRanges(t *testing.T) {
+	two := NewHashRing([]ShardingName{"ds_0", "ds_1"}, 16, nil)
+	three := NewHashRing([]ShardingName{"ds_0", "ds_1", "ds_2"}, 16, nil)
+	tests := []struct {
+		name   string
+		before *HashRing
+		after  *HashRing
+		from   ShardingName
+		to     ShardingName
+	}{
+		{name: "add data node", before: two, after: three, to: "ds_2"},
+		{name: "remove data node", before: three, after: two, from: "ds_2"},
+		{name: "unchanged", before: two, after: NewHashRing([]ShardingName{"ds_1", "ds_0"}, 16, nil)},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			moved := MovedRanges(tt.before, tt.after)
+			for i, r := range moved {
+				if r.From == r.To || (tt.from != "" && r.From != tt.from) || (tt.to != "" && r.To != tt.to) {
+					t.Errorf("range %d moved from %s to %s", i, r.From, r.To)
+				}
+				if next := moved[(i+1)%len(moved)]; len(moved) > 1 && r.End == next.Start && r.From == next.From && r.To == next.To {
+					t.Errorf("range %d not merged with the next range", i)
+				}
+			}
+			if changed := tt.from != "" || tt.to != ""; changed != (len(moved) > 0) {
+				t.Fatalf("moved = %v, want changed %v", moved, changed)
+			}
+			// 归属变化的键恰好位于迁移区间内，且迁移方向一致
+			for i := 0; i < 5000; i++ {
+				key := fmt.Sprint(i)
+				from, to := tt.before.Locate(key), tt.after.Locate(key)
+				var in *HashRange
+				for j := range moved {
+					if inHashRange(hashKey(key), moved[j]) {
+						in = &moved[j]
+						break
+					}
+				}
+				switch {
+				case from == to && in != nil:
+					t.Fatalf("key %s on %s is in moved range %v", key, from, *in)
+				case from != to && (in == nil || in.From != from || in.To != to):
+					t.Fatalf("key %s moved from %s to %s, range %v", key, from, to, in)
+				}
+			}
+		})
+	}
+}
+
+func TestDbConsistentHashPolicyResolve(t *testing.T) {
+	connPools := map[ShardingName][]gorm.ConnPool{
+		"ds_0": {&testConnPool{name: "ds_0"}},
+		"ds_1": {&testConnPool{name: "ds_1"}},
+		"ds_2": {&testConnPool{name: "ds_2"}},
+	}
+	policy := &DbConsistentHashPolicy{DataShardingRuleModelMap: map[string]DataShardingRuleModel{"order": {
+		Table:                     "order",
+		DatabaseShardingParameter: "user_id",
+	}}}
+	ring := policy.Ring("ds_2", "ds_1", "ds_0")
+	for _, userID := range []int64{1, 42, 1000} {
+		result := policy.Resolve(context.Background(), connPools, "order", fmt.Sprintf("SELECT * FROM `order` WHERE user_id = %d", userID), logger.Discard)
+		if result.Error != nil {
+			t.Fatal(result.Error)
+		}
+		if want := ring.Locate(fmt.Sprint(userID)); result.Name != want || result.ConnPool.(*testConnPool).name != string(want) {
+			t.Errorf("user %d routed to %s, want %s", userID, result.Name, want)
+		}
+	}
+	if result := policy.Resolve(context.Background(), connPools, "order", "SELECT * FROM `order`", logger.Discard); len(result.Scatter) != 3 {
+		t.Errorf("scatter = %d, want 3", len(result.Scatter))
+	}
+}
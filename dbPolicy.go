@@ -20,6 +20,60 @@ type DbPolicy interface {
 type DbPolicyResult struct {
 	Name     ShardingName
 	ConnPool gorm.ConnPool
+	// Scatter 未命中分库键时需分散执行的全部数据源
+	Scatter []DbPolicyResult
+	// Error 无法计算分库时的错误，由路由返回给语句
+	Error error
+}
+
+// targets 需要执行的全部数据源
+func (r DbPolicyResult) targets() []DbPolicyResult {
+	if len(r.Scatter) > 0 {
+		return r.Scatter
+	}
+	return []DbPolicyResult{r}
+}
+
+// scatterPolicyResult 每个数据源随机选取一个连接池，names 为空时使用全部数据源，按名称自然排序保证执行顺序稳定
+func scatterPolicyResult(connPoolsMap map[ShardingName][]gorm.ConnPool, names ...ShardingName) (result DbPolicyResult) {
+	if len(names) == 0 {
+		for name := range connPoolsMap {
+			names = append(names, name)
+		}
+	}
+	sortShardingNames(names)
+	for _, name := range names {
+		target := randomConnPool(name, connPoolsMap[name])
+		if target.Error != nil {
+			return target
+		}
+		result.Scatter = append(result.Scatter, target)
+	}
+	if len(result.Scatter) > 0 {
+		result.Name, result.ConnPool = result.Scatter[0].Name, result.Scatter[0].ConnPool
+	}
+	if len(result.Scatter) == 1 {
+		result.Scatter = nil
+	}
+	return result
+}
+
+// randomConnPool 在数据源的连接池中随机选取一个，数据源未配置连接池时返回错误
+func randomConnPool(name ShardingName, connPools []gorm.ConnPool) DbPolicyResult {
+	if len(connPools) == 0 {
+		return DbPolicyResult{Error: fmt.Errorf("data source %s is not configured", name)}
+	}
+	return DbPolicyResult{Name: name, ConnPool: connPools[rand.Intn(len(connPools))]}
+}
+
+// checkShardingNames 计算得到的数据源须已配置
+func checkShardingNames(connPoolsMap map[ShardingName][]gorm.ConnPool, names ...ShardingName) error {
+	for _, name := range names {
+		if len(connPoolsMap[name]) == 0 {
+			return fmt.Errorf("data source %s is not configured", name)
+		}
+	}
+	return nil
 }
 
 // DbRandomPolicy 随机路由
@@ -29,7 +83,7 @@ type DbRandomPolicy struct {
 func (DbRandomPolicy) Resolve(_ context.Context, connPoolsMap map[ShardingName][]gorm.ConnPool, _ string, _ string, _ logger.Interface) (result DbPolicyResult) {
 	result = DbPolicyResult{}
 	for name, connPools := range connPoolsMap {
-		result = DbPolicyResult{Name: name, ConnPool: connPools[rand.Intn(len(connPools))]}
+		result = randomConnPool(name, connPools)
 		break
 	}
 
@@ -42,29 +96,34 @@ type DbShardingRoutePolicy struct {
 	DataShardingRuleModelMap map[string]DataShardingRuleModel
 }
 
+func (p *DbShardingRoutePolicy) validate() error {
+	return validateRules(p.DataShardingRuleModelMap)
+}
+
 func (p *DbShardingRoutePolicy) Resolve(ctx context.Context, connPoolsMap map[ShardingName][]gorm.ConnPool, tableName string, sql string, log logger.Interface) (result DbPolicyResult) {
 	result = DbPolicyResult{}
 	if _, ok := p.DataShardingRuleModelMap[tableName]; !ok {
 		// 不存在，走随机路由
 		for name, connPools := range connPoolsMap {
-			result = DbPolicyResult{Name: name, ConnPool: connPools[rand.Intn(len(connPools))]}
-			return result
+			return randomConnPool(name, connPools)
 		}
 	}
 	dbIndexVal := ctx.Value(fmt.Sprintf(string(ShardingDbIndex), tableName))
 	if dbIndexVal != nil {
 		// 预设好了索引，直接获取并返回
-		shardingKey := ShardingName(dbIndexVal.(string))
+		dbIndex, ok := dbIndexVal.(string)
+		if !ok {
+			return DbPolicyResult{Error: fmt.Errorf("database pre_set sharding of %s: %T is not a string", tableName, dbIndexVal)}
+		}
+		shardingKey := ShardingName(dbIndex)
 		log.Info(ctx, "database pre_set sharding: %v", shardingKey)
-		connPools := connPoolsMap[shardingKey]
-		return DbPolicyResult{Name: shardingKey, ConnPool: connPools[rand.Intn(len(connPools))]}
+		return randomConnPool(shardingKey, connPoolsMap[shardingKey])
 	}
 	model := p.DataShardingRuleModelMap[tableName]
-	if model.DatabaseShardingParameter == "" && model.DatabaseDefaultShardingValue == "" {
+	if len(model.databaseShardingParameters()) == 0 && model.DatabaseDefaultShardingValue == "" {
 		// 不存在，走随机路由
 		for name, connPools := range connPoolsMap {
-			result = DbPolicyResult{Name: name, ConnPool: connPools[rand.Intn(len(connPools))]}
-			return result
+			return randomConnPool(name, connPools)
 		}
 	}
 	var connPools []gorm.ConnPool
@@ -73,15 +132,41 @@ func (p *DbShardingRoutePolicy) Resolve(ctx context.Context, connPoolsMap map[Sh
 		shardingKey = ShardingName(model.DatabaseDefaultShardingValue)
 		connPools = connPoolsMap[ShardingName(model.DatabaseDefaultShardingValue)]
 	} else {
-		// 分库键值
-		value := GetSqlParameterValue(sql, model.DatabaseShardingParameter)
-		expressionResult := parseExpression(model.DatabaseShardingParameter, model.DatabaseShardingExpression, value)
-		shardingKey = expressionResult.(ShardingName)
+		// 分库键条件
+		conds, err := model.shardingConditions(sql, model.databaseShardingParameters())
+		if err != nil {
+			return DbPolicyResult{Error: fmt.Errorf("database sharding of %s: %w", tableName, err)}
+		}
+		targets, ok, err := conds.targets(model.databaseSharding(shardingNames(connPoolsMap)))
+		if err != nil {
+			return DbPolicyResult{Error: fmt.Errorf("database sharding of %s: %w", tableName, err)}
+		}
+		if !ok {
+			// 无法确定分库，分散到全部数据源
+			result = scatterPolicyResult(connPoolsMap)
+			log.Info(ctx, "database scatter: %v", len(result.Scatter))
+			return result
+		}
+		if len(targets) > 1 {
+			names := make([]ShardingName, len(targets))
+			for i, target := range targets {
+				names[i] = ShardingName(target)
+			}
+			log.Info(ctx, "database sharding: %v", targets)
+			if err = checkShardingNames(connPoolsMap, names...); err != nil {
+				return DbPolicyResult{Error: fmt.Errorf("database sharding of %s: %w", tableName, err)}
+			}
+			return scatterPolicyResult(connPoolsMap, names...)
+		}
+		shardingKey = ShardingName(targets[0])
 		log.Info(ctx, "database sharding: %v", shardingKey)
 		// 归属的连接池
 		connPools = connPoolsMap[shardingKey]
 	}
+	if len(connPools) == 0 {
+		return DbPolicyResult{Error: fmt.Errorf("database sharding of %s: data source %s is not configured", tableName, shardingKey)}
+	}
 
 	// 随机选取一个连接池
-	return DbPolicyResult{Name: shardingKey, ConnPool: connPools[rand.Intn(len(connPools))]}
+	return randomConnPool(shardingKey, connPools)
 }
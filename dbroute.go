@@ -3,7 +3,9 @@ package dbroute
 import (
 	"database/sql"
 	"errors"
+	"fmt"
 	"gorm.io/gorm"
+	"sort"
 	"sync"
 	"time"
 )
@@ -27,6 +29,10 @@ type DBRoute struct {
 	global           *route
 	prepareStmtStore map[gorm.ConnPool]*gorm.PreparedStmtDB
 	compileCallbacks []func(gorm.ConnPool) error
+	// 各连接池对应的方言，用于生成建表语句
+	dialectors map[gorm.ConnPool]gorm.Dialector
+	// 插件初始化后注册失败的表及错误，这些表上的语句返回该错误
+	registerErrors map[string]error
 }
 
 type Config struct {
@@ -39,6 +45,14 @@ type Config struct {
 	TbPolicy TbPolicy
 	// 打印路由信息
 	TraceRouteMode bool
+	// 绑定表组，同组表按相同规则分片，关联查询时各表路由到相同后缀的物理表，如 {"order", "order_item"}
+	BindingTables [][]string
+	// 广播表，如字典表 region、currency，每个数据源保存全量数据：写操作在全部主库执行，读操作任选一个连接池
+	Broadcast bool
+	// 写入时自动创建缺失的物理表，以逻辑表为模板（MySQL CREATE TABLE ... LIKE，Postgres LIKE ... INCLUDING ALL），逻辑表须存在于对应数据源
+	AutoCreateTable bool
+	// 主键生成器，插入前为模型中标记 generated 的零值主键生成全局唯一的主键，如 NewSnowflake
+	KeyGenerator KeyGenerator
 	// 对应表
 	tables []string
 }
@@ -65,6 +79,10 @@ func (dr *DBRoute) Register(config Config, tables ...string) *DBRoute {
 		dr.routes = map[string]*route{}
 	}
 
+	if dr.dialectors == nil {
+		dr.dialectors = map[gorm.ConnPool]gorm.Dialector{}
+	}
+
 	if config.DbPolicy == nil {
 		config.DbPolicy = DbRandomPolicy{}
 	}
@@ -75,11 +93,76 @@ func (dr *DBRoute) Register(config Config, tables ...string) *DBRoute {
 	config.tables = tables
 	dr.configs = append(dr.configs, config)
 	if dr.DB != nil {
-		dr.compileConfig(config)
+		// 插件已初始化，无法再通过 Initialize 返回错误，记录错误由 RegisterError 及这些表上的语句返回
+		if err := dr.registerConfig(config); err != nil {
+			if dr.registerErrors == nil {
+				dr.registerErrors = map[string]error{}
+			}
+			if len(tables) == 0 {
+				tables = []string{""}
+			}
+			for _, table := range tables {
+				dr.registerErrors[table] = err
+			}
+		}
 	}
 	return dr
 }
 
+// registerConfig 插件初始化后注册的配置，校验通过后建立连接并注册路由
+func (dr *DBRoute) registerConfig(config Config) error {
+	if err := config.validate(); err != nil {
+		return err
+	}
+	if err := validateLookupDirectories(dr.configs); err != nil {
+		return err
+	}
+	if err := dr.compileConfig(config); err != nil {
+		return err
+	}
+	for _, table := range config.tables {
+		if err := dr.routes[table].validateBindingTables(); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// RegisterError 插件初始化后调用 Register 时注册失败的错误
+func (dr *DBRoute) RegisterError() error {
+	tables := make([]string, 0, len(dr.registerErrors))
+	for table := range dr.registerErrors {
+		tables = append(tables, table)
+	}
+	sort.Strings(tables)
+	errs := make([]error, 0, len(tables))
+	seen := make(map[error]bool, len(tables))
+	for _, table := range tables {
+		if err := dr.registerErrors[table]; !seen[err] {
+			seen[err] = true
+			errs = append(errs, err)
+		}
+	}
+	return errors.Join(errs...)
+}
+
+// policyValidator 注册时校验配置的路由策略，如预编译分片表达式
+type policyValidator interface {
+	validate() error
+}
+
+// validate 校验分库、分表策略
+func (c Config) validate() error {
+	for _, policy := range []interface{}{c.DbPolicy, c.TbPolicy} {
+		if validator, ok := policy.(policyValidator); ok {
+			if err := validator.validate(); err != nil {
+				return fmt.Errorf("route of %v: %w", c.tables, err)
+			}
+		}
+	}
+	return nil
+}
+
 func (dr *DBRoute) Name() string {
 	return "gorm:db_route"
 }
@@ -98,16 +181,36 @@ func (dr *DBRoute) Collect(fc func(rows *sql.Rows, err error)) {
 
 func (dr *DBRoute) Initialize(db *gorm.DB) error {
 	dr.DB = db
+	if err := dr.compile(); err != nil {
+		return err
+	}
 	dr.registerCallbacks(db)
-	return dr.compile()
+	// 在默认连接上开启的事务中，路由到其它数据源的语句随事务在对应数据源上开启事务
+	db.Statement.ConnPool = &txConnPool{ConnPool: db.Statement.ConnPool}
+	return nil
 }
 
 func (dr *DBRoute) compile() error {
+	// 全部配置校验通过后再建立连接、注册路由
+	for _, config := range dr.configs {
+		if err := config.validate(); err != nil {
+			return err
+		}
+	}
+	if err := validateLookupDirectories(dr.configs); err != nil {
+		return err
+	}
 	for _, config := range dr.configs {
 		if err := dr.compileConfig(config); err != nil {
 			return err
 		}
 	}
+	// 绑定表可能由其它配置注册，全部路由注册后再校验
+	for _, r := range dr.routes {
+		if err := r.validateBindingTables(); err != nil {
+			return err
+		}
+	}
 	return nil
 }
 
@@ -115,19 +218,30 @@ func (dr *DBRoute) compileConfig(config Config) (err error) {
 	var (
 		connPool = dr.DB.Config.ConnPool
 		r        = route{
-			dbPolicy:       config.DbPolicy,
-			tbPolicy:       config.TbPolicy,
-			dbRoute:        dr,
-			traceRouteMode: config.TraceRouteMode,
+			dbPolicy:        config.DbPolicy,
+			tbPolicy:        config.TbPolicy,
+			dbRoute:         dr,
+			traceRouteMode:  config.TraceRouteMode,
+			bindingTables:   map[string][]string{},
+			broadcast:       config.Broadcast,
+			autoCreateTable: config.AutoCreateTable,
+			keyGenerator:    config.KeyGenerator,
 		}
 	)
 
+	for _, group := range config.BindingTables {
+		for _, table := range group {
+			r.bindingTables[table] = group
+		}
+	}
+
 	if preparedStmtDB, ok := connPool.(*gorm.PreparedStmtDB); ok {
 		connPool = preparedStmtDB.ConnPool
 	}
 
 	if len(config.Masters) == 0 {
 		r.masters = map[ShardingName][]gorm.ConnPool{Default: {connPool}}
+		dr.dialectors[connPool] = dr.DB.Dialector
 	} else if r.masters, err = dr.convertToConnPool(config.Masters); err != nil {
 		return err
 	}
@@ -173,6 +287,7 @@ func (dr *DBRoute) convertToConnPool(dialectorsMap map[ShardingName]DialectorCon
 					connPool = preparedStmtDB.ConnPool
 				}
 
+				dr.dialectors[connPool] = dialector
 				dr.prepareStmtStore[connPool] = &gorm.PreparedStmtDB{
 					ConnPool:    db.Config.ConnPool,
 					Stmts:       map[string]*gorm.Stmt{},
@@ -194,60 +309,28 @@ func (dr *DBRoute) convertToConnPool(dialectorsMap map[ShardingName]DialectorCon
 	return connPoolMap, err
 }
 
-// routeTb
+// lookupRoute
 //
-//	@Description: 通过重写sql路由指定分表
+//	@Description: 按 Use、表名、模型表名依次查找路由配置，均未命中时使用全局路由
 //	@param stmt
-//	@param sql
-//	@return string
-func (dr *DBRoute) routeTb(stmt *gorm.Statement) string {
-	sql := stmt.SQL.String()
-	if len(dr.routes) > 0 {
-		if u, ok := stmt.Clauses[usingName].Expression.(using); ok && u.Use != "" {
-			if r, ok := dr.routes[u.Use]; ok {
-				return r.rewriteSql(stmt, sql)
-			}
-		}
-		if stmt.Table != "" {
-			if r, ok := dr.routes[stmt.Table]; ok {
-				return r.rewriteSql(stmt, sql)
-			}
-		}
-		if stmt.Schema != nil {
-			if r, ok := dr.routes[stmt.Schema.Table]; ok {
-				return r.rewriteSql(stmt, sql)
-			}
-		}
-	}
-	if dr.global != nil {
-		return dr.global.rewriteSql(stmt, sql)
-	}
-	return sql
-}
-
-// @title    路由
-// @description   路由指定分库
-// @auth jerry
-func (dr *DBRoute) routeDb(stmt *gorm.Statement, sql string, op Operation) gorm.ConnPool {
+//	@return *route
+func (dr *DBRoute) lookupRoute(stmt *gorm.Statement) *route {
 	if len(dr.routes) > 0 {
 		if u, ok := stmt.Clauses[usingName].Expression.(using); ok && u.Use != "" {
 			if r, ok := dr.routes[u.Use]; ok {
-				return r.route(stmt, sql, op)
+				return r
 			}
 		}
 		if stmt.Table != "" {
 			if r, ok := dr.routes[stmt.Table]; ok {
-				return r.route(stmt, sql, op)
+				return r
 			}
 		}
 		if stmt.Schema != nil {
 			if r, ok := dr.routes[stmt.Schema.Table]; ok {
-				return r.route(stmt, sql, op)
+				return r
 			}
 		}
 	}
-	if dr.global != nil {
-		return dr.global.route(stmt, sql, op)
-	}
-	return stmt.ConnPool
+	return dr.global
 }
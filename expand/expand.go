@@ -12,6 +12,8 @@ import (
 //
 //	@Description: 清空where条件中的前置表名
 //	@param db
+//
+// Deprecated: 改写表名时已同步更新以表名限定的列，不再需要清空
 func ClearWhereTableName(db *gorm.DB) {
 	// 前置将Exprs[]#Cloumn的Table清空，避免出现携带条件带表名
 	if cs, ok := db.Statement.Clauses["WHERE"]; ok {
@@ -104,6 +106,7 @@ func PreBuildSql(db *gorm.DB) {
 		switch db.Statement.BuildClauses[0] {
 		case "INSERT":
 			db.Statement.SQL.Grow(180)
+			addReturning(db)
 			db.Statement.AddClauseIfNotExists(clause.Insert{})
 			db.Statement.AddClause(callbacks.ConvertToCreateValues(db.Statement))
 			db.Statement.Build(db.Statement.BuildClauses...)
@@ -146,3 +149,23 @@ func PreBuildSql(db *gorm.DB) {
 		}
 	}
 }
+
+// addReturning 与 gorm:create 一致，支持 RETURNING 的方言（创建子句含 RETURNING）返回有数据库默认值的列，如自增主键
+func addReturning(db *gorm.DB) {
+	if db.Statement.Schema == nil || len(db.Statement.Schema.FieldsWithDefaultDBValue) == 0 {
+		return
+	}
+	if _, ok := db.Statement.Clauses["RETURNING"]; ok {
+		return
+	}
+	for _, name := range db.Statement.BuildClauses {
+		if name == "RETURNING" {
+			columns := make([]clause.Column, 0, len(db.Statement.Schema.FieldsWithDefaultDBValue))
+			for _, field := range db.Statement.Schema.FieldsWithDefaultDBValue {
+				columns = append(columns, clause.Column{Name: field.DBName})
+			}
+			db.Statement.AddClause(clause.Returning{Columns: columns})
+			return
+		}
+	}
+}
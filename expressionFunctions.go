@@ -0,0 +1,296 @@
+package dbroute
+
+import (
+	"crypto/md5"
+	"fmt"
+	"gorm/dbroute/util/str"
+	"hash/crc32"
+	"math"
+	"math/big"
+	"strconv"
+	"strings"
+	"time"
+	"unicode/utf8"
+)
+
+// 内置分片表达式函数，与 Java 中常用的分片公式保持一致，便于跨服务计算出相同的分片
+
+// crc32Function crc32(x) 字符串的 CRC32（IEEE），同 java.util.zip.CRC32#getValue
+func crc32Function(args ...interface{}) (interface{}, error) {
+	if err := checkArgs("crc32", args, 1, 1); err != nil {
+		return nil, err
+	}
+	return float64(crc32.ChecksumIEEE([]byte(expressionString(args[0])))), nil
+}
+
+// murmur3Function murmur3(x[, seed]) 字符串的 MurmurHash3 x86_32，有符号整数，同 Guava Hashing.murmur3_32(seed).hashString(x, UTF_8).asInt()
+func murmur3Function(args ...interface{}) (interface{}, error) {
+	if err := checkArgs("murmur3", args, 1, 2); err != nil {
+		return nil, err
+	}
+	var seed int64
+	if len(args) == 2 {
+		var err error
+		if seed, err = toInt64(args[1]); err != nil {
+			return nil, fmt.Errorf("murmur3 seed: %w", err)
+		}
+	}
+	return float64(str.Murmur3(expressionString(args[0]), uint32(seed))), nil
+}
+
+// md5ModFunction md5mod(x, n) 字符串 MD5 摘要作为无符号大整数对 n 取模，同 new BigInteger(1, md5).mod(n)
+func md5ModFunction(args ...interface{}) (interface{}, error) {
+	if err := checkArgs("md5mod", args, 2, 2); err != nil {
+		return nil, err
+	}
+	n, err := toInt64(args[1])
+	if err != nil {
+		return nil, fmt.Errorf("md5mod: %w", err)
+	}
+	if n <= 0 {
+		return nil, fmt.Errorf("md5mod: modulus must be positive, got %d", n)
+	}
+	digest := md5.Sum([]byte(expressionString(args[0])))
+	mod := new(big.Int).Mod(new(big.Int).SetBytes(digest[:]), big.NewInt(n))
+	return float64(mod.Int64()), nil
+}
+
+// substrFunction substr(s, begin[, end]) 按字符截取 [begin, end)，同 String#substring
+func substrFunction(args ...interface{}) (interface{}, error) {
+	if err := checkArgs("substr", args, 2, 3); err != nil {
+		return nil, err
+	}
+	runes := []rune(expressionString(args[0]))
+	begin, err := toInt64(args[1])
+	if err != nil {
+		return nil, fmt.Errorf("substr: %w", err)
+	}
+	end := int64(len(runes))
+	if len(args) == 3 {
+		if end, err = toInt64(args[2]); err != nil {
+			return nil, fmt.Errorf("substr: %w", err)
+		}
+	}
+	if begin < 0 || end > int64(len(runes)) || begin > end {
+		return nil, fmt.Errorf("substr: range [%d, %d) out of bounds for %q", begin, end, string(runes))
+	}
+	return string(runes[begin:end]), nil
+}
+
+// lpadFunction lpad(x, size[, pad]) 左侧填充到指定字符数，默认填充 0，超出时不截断，同 StringUtils.leftPad
+func lpadFunction(args ...interface{}) (interface{}, error) {
+	if err := checkArgs("lpad", args, 2, 3); err != nil {
+		return nil, err
+	}
+	s := expressionString(args[0])
+	size, err := toInt64(args[1])
+	if err != nil {
+		return nil, fmt.Errorf("lpad: %w", err)
+	}
+	pad := "0"
+	if len(args) == 3 {
+		pad = expressionString(args[2])
+	}
+	n := int(size) - utf8.RuneCountInString(s)
+	if n <= 0 || pad == "" {
+		return s, nil
+	}
+	padding := []rune(strings.Repeat(pad, n/utf8.RuneCountInString(pad)+1))[:n]
+	return string(padding) + s, nil
+}
+
+// ExpressionLocation 分片表达式中时间使用的时区：不带时区的时间字符串按该时区解析，时间戳及带时区的时间转为该时区后格式化
+var ExpressionLocation = time.UTC
+
+// dateFormatFunction dateFormat(t, pattern) 按 SimpleDateFormat 格式（如 yyyyMM）格式化时间，
+// t 为时间字符串或毫秒时间戳，均按 ExpressionLocation 格式化
+func dateFormatFunction(args ...interface{}) (interface{}, error) {
+	if err := checkArgs("dateFormat", args, 2, 2); err != nil {
+		return nil, err
+	}
+	location := ExpressionLocation
+	var t time.Time
+	switch v := args[0].(type) {
+	case time.Time:
+		t = v
+	case float64, int64, int:
+		millis, err := toInt64(v)
+		if err != nil {
+			return nil, fmt.Errorf("dateFormat: %w", err)
+		}
+		t = time.UnixMilli(millis)
+	default:
+		s := expressionString(v)
+		var err error
+		for _, layout := range datetimeLayouts {
+			if t, err = time.ParseInLocation(layout, s, location); err == nil {
+				break
+			}
+		}
+		if err != nil {
+			return nil, fmt.Errorf("dateFormat: %q is not a datetime", s)
+		}
+	}
+	return formatJavaDate(t.In(location), expressionString(args[1])), nil
+}
+
+// modFunction mod(a, b) 整数取余，符号与被除数相同，同 Java 的 a % b
+func modFunction(args ...interface{}) (interface{}, error) {
+	if err := checkArgs("mod", args, 2, 2); err != nil {
+		return nil, err
+	}
+	a, err := toInt64(args[0])
+	if err != nil {
+		return nil, fmt.Errorf("mod: %w", err)
+	}
+	b, err := toInt64(args[1])
+	if err != nil {
+		return nil, fmt.Errorf("mod: %w", err)
+	}
+	if b == 0 {
+		return nil, fmt.Errorf("mod: division by zero")
+	}
+	return float64(a % b), nil
+}
+
+// floorDivFunction floorDiv(a, b) 向下取整的整数除法，同 Math.floorDiv
+func floorDivFunction(args ...interface{}) (interface{}, error) {
+	if err := checkArgs("floorDiv", args, 2, 2); err != nil {
+		return nil, err
+	}
+	a, err := toInt64(args[0])
+	if err != nil {
+		return nil, fmt.Errorf("floorDiv: %w", err)
+	}
+	b, err := toInt64(args[1])
+	if err != nil {
+		return nil, fmt.Errorf("floorDiv: %w", err)
+	}
+	if b == 0 {
+		return nil, fmt.Errorf("floorDiv: division by zero")
+	}
+	q := a / b
+	if a%b != 0 && (a < 0) != (b < 0) {
+		q--
+	}
+	return exactNumber(q), nil
+}
+
+// absFunction abs(x) 绝对值
+func absFunction(args ...interface{}) (interface{}, error) {
+	if err := checkArgs("abs", args, 1, 1); err != nil {
+		return nil, err
+	}
+	switch v := args[0].(type) {
+	case float64:
+		return math.Abs(v), nil
+	case string:
+		if _, err := strconv.ParseInt(v, 10, 64); err == nil {
+			// 整数字符串（如超出 ±2^53 的取值）按整数精确计算
+			break
+		}
+		f, err := strconv.ParseFloat(v, 64)
+		if err != nil {
+			return nil, fmt.Errorf("abs: %q is not a number", v)
+		}
+		return math.Abs(f), nil
+	}
+	i, err := toInt64(args[0])
+	if err != nil {
+		return nil, fmt.Errorf("abs: %w", err)
+	}
+	if i < 0 {
+		i = -i
+	}
+	return exactNumber(i), nil
+}
+
+// exactNumber 整数结果，超出 ±2^53 时以十进制字符串返回，避免 float64 舍入，可继续作为函数参数
+func exactNumber(i int64) interface{} {
+	if i > maxExactInteger || i < -maxExactInteger {
+		return strconv.FormatInt(i, 10)
+	}
+	return float64(i)
+}
+
+func checkArgs(name string, args []interface{}, min, max int) error {
+	if len(args) < min || len(args) > max {
+		if min == max {
+			return fmt.Errorf("%s expects %d arguments, got %d", name, min, len(args))
+		}
+		return fmt.Errorf("%s expects %d to %d arguments, got %d", name, min, max, len(args))
+	}
+	return nil
+}
+
+// expressionString 参数转为字符串，整数值的浮点数不带小数及指数
+func expressionString(v interface{}) string {
+	if f, ok := v.(float64); ok {
+		return strconv.FormatFloat(f, 'f', -1, 64)
+	}
+	return toString(v)
+}
+
+// formatJavaDate 按 SimpleDateFormat 的 y、M（MMM 为月份缩写，MMMM 为全称）、d、H、h、m、s、S 格式化，单引号内为原样输出的文本
+func formatJavaDate(t time.Time, pattern string) string {
+	var builder strings.Builder
+	runes := []rune(pattern)
+	for i := 0; i < len(runes); {
+		c := runes[i]
+		if c == '\'' {
+			end := i + 1
+			for end < len(runes) && runes[end] != '\'' {
+				end++
+			}
+			if end == i+1 {
+				builder.WriteRune('\'')
+			} else {
+				builder.WriteString(string(runes[i+1 : end]))
+			}
+			i = end + 1
+			continue
+		}
+		n := 1
+		for i+n < len(runes) && runes[i+n] == c {
+			n++
+		}
+		i += n
+		var value int
+		switch c {
+		case 'y':
+			value = t.Year()
+			if n == 2 {
+				value %= 100
+			}
+		case 'M':
+			if n >= 4 {
+				builder.WriteString(t.Month().String())
+				continue
+			} else if n == 3 {
+				builder.WriteString(t.Month().String()[:3])
+				continue
+			}
+			value = int(t.Month())
+		case 'd':
+			value = t.Day()
+		case 'H':
+			value = t.Hour()
+		case 'h':
+			value = t.Hour() % 12
+			if value == 0 {
+				value = 12
+			}
+		case 'm':
+			value = t.Minute()
+		case 's':
+			value = t.Second()
+		case 'S':
+			value = t.Nanosecond() / int(time.Millisecond)
+		default:
+			builder.WriteString(strings.Repeat(string(c), n))
+			continue
+		}
+		builder.WriteString(fmt.Sprintf("%0*d", n, value))
+	}
+	return builder.String()
+}
@@ -0,0 +1,56 @@
+package dbroute
+
+import (
+	"reflect"
+	"testing"
+)
+
+// 数值参数与 govaluate 传入函数时一致为 float64，哈希类期望值取自 Java / Guava
+func TestExpressionFunctions(t *testing.T) {
+	tests := []struct {
+		name     string
+		function string
+		args     []interface{}
+		want     interface{}
+		wantErr  bool
+	}{
+		{name: "crc32 check value", function: "crc32", args: []interface{}{"123456789"}, want: float64(3421780262)},
+		{name: "crc32 number", function: "crc32", args: []interface{}{"hello"}, want: float64(907060870)},
+		{name: "crc32 arity", function: "crc32", args: []interface{}{}, wantErr: true},
+		{name: "murmur3", function: "murmur3", args: []interface{}{"The quick brown fox jumps over the lazy dog"}, want: float64(776992547)},
+		{name: "murmur3 negative", function: "murmur3", args: []interface{}{"", float64(0xffffffff)}, want: float64(-2114883783)},
+		{name: "md5mod", function: "md5mod", args: []interface{}{"hello", float64(16)}, want: float64(2)},
+		{name: "md5mod not power of two", function: "md5mod", args: []interface{}{"hello", float64(10)}, want: float64(4)},
+		{name: "md5mod zero", function: "md5mod", args: []interface{}{"hello", float64(0)}, wantErr: true},
+		{name: "hashcode", function: "hashcode", args: []interface{}{float64(1234567)}, want: float64(2018166324)},
+		{name: "hashcode decimal", function: "hashcode", args: []interface{}{1.5}, want: float64(48568)},
+		{name: "parse", function: "parse", args: []interface{}{"order_", float64(1234567)}, want: "order_1234567"},
+		{name: "mod", function: "mod", args: []interface{}{float64(-7), float64(3)}, want: float64(-1)},
+		{name: "mod exact", function: "mod", args: []interface{}{"1152921504606846977", float64(16)}, want: float64(1)},
+		{name: "mod zero", function: "mod", args: []interface{}{float64(1), float64(0)}, wantErr: true},
+		{name: "floorDiv", function: "floorDiv", args: []interface{}{float64(-7), float64(2)}, want: float64(-4)},
+		{name: "floorDiv exact", function: "floorDiv", args: []interface{}{"1152921504606846976", float64(1)}, want: "1152921504606846976"},
+		{name: "abs", function: "abs", args: []interface{}{-3.5}, want: 3.5},
+		{name: "abs exact", function: "abs", args: []interface{}{"-1152921504606846976"}, want: "1152921504606846976"},
+		{name: "lpad", function: "lpad", args: []interface{}{float64(7), float64(3)}, want: "007"},
+		{name: "lpad pad", function: "lpad", args: []interface{}{"x", float64(4), "ab"}, want: "abax"},
+		{name: "lpad longer", function: "lpad", args: []interface{}{"hello", float64(2)}, want: "hello"},
+		{name: "substr", function: "substr", args: []interface{}{"hello", float64(1), float64(3)}, want: "el"},
+		{name: "substr out of bounds", function: "substr", args: []interface{}{"hello", float64(4), float64(9)}, wantErr: true},
+		{name: "dateFormat string", function: "dateFormat", args: []interface{}{"2026-03-05 10:11:12", "yyyyMM"}, want: "202603"},
+		{name: "dateFormat millis", function: "dateFormat", args: []interface{}{float64(1772705472000), "yyyy-MM-dd'T'HH:mm:ss.SSS"}, want: "2026-03-05T10:11:12.000"},
+		{name: "dateFormat month names", function: "dateFormat", args: []interface{}{"2026-03-05", "MMM MMMM"}, want: "Mar March"},
+		{name: "dateFormat invalid", function: "dateFormat", args: []interface{}{"yesterday", "yyyyMM"}, wantErr: true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, err := expressionFunctions[tt.function](tt.args...)
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("err = %v, wantErr %v", err, tt.wantErr)
+			}
+			if !tt.wantErr && !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("%s(%v) = %#v, want %#v", tt.function, tt.args, got, tt.want)
+			}
+		})
+	}
+}
@@ -0,0 +1,262 @@
+package dbroute
+
+import (
+	"database/sql/driver"
+	"fmt"
+	"github.com/xwb1989/sqlparser"
+	"math/big"
+	"strconv"
+	"strings"
+)
+
+// evalExpr
+//
+//	@Description: 在归并后的行上计算 HAVING 条件或表达式列，列与聚合函数取自查询列
+//	@param expr
+//	@param refs	列与聚合函数对应的查询列
+//	@param row
+//	@param columns
+//	@param args	原始参数，用于取 HAVING 中占位符的值
+//	@return driver.Value
+//	@return error
+func (p *mergePlan) evalExpr(expr sqlparser.Expr, refs map[string]columnRef, row []driver.Value, columns []string, args []interface{}) (driver.Value, error) {
+	switch e := expr.(type) {
+	case *sqlparser.AndExpr:
+		left, err := p.evalExpr(e.Left, refs, row, columns, args)
+		if err != nil || !truthy(left) {
+			return false, err
+		}
+		right, err := p.evalExpr(e.Right, refs, row, columns, args)
+		return truthy(right), err
+	case *sqlparser.OrExpr:
+		left, err := p.evalExpr(e.Left, refs, row, columns, args)
+		if err != nil || truthy(left) {
+			return truthy(left), err
+		}
+		right, err := p.evalExpr(e.Right, refs, row, columns, args)
+		return truthy(right), err
+	case *sqlparser.NotExpr:
+		value, err := p.evalExpr(e.Expr, refs, row, columns, args)
+		return !truthy(value), err
+	case *sqlparser.ParenExpr:
+		return p.evalExpr(e.Expr, refs, row, columns, args)
+	case *sqlparser.ComparisonExpr:
+		return p.evalComparison(e, refs, row, columns, args)
+	case *sqlparser.BinaryExpr:
+		left, err := p.evalExpr(e.Left, refs, row, columns, args)
+		if err != nil {
+			return nil, err
+		}
+		right, err := p.evalExpr(e.Right, refs, row, columns, args)
+		if err != nil {
+			return nil, err
+		}
+		return arithmetic(e.Operator, left, right)
+	case *sqlparser.UnaryExpr:
+		if e.Operator != sqlparser.UMinusStr {
+			break
+		}
+		value, err := p.evalExpr(e.Expr, refs, row, columns, args)
+		if err != nil {
+			return nil, err
+		}
+		return arithmetic(sqlparser.MinusStr, int64(0), value)
+	case *sqlparser.FuncExpr, *sqlparser.ColName:
+		ref, ok := refs[sqlparser.String(e)]
+		if fn, isFunc := e.(*sqlparser.FuncExpr); !ok && isFunc && isCoalesce(fn) {
+			return p.evalCoalesce(fn, refs, row, columns, args)
+		}
+		if !ok {
+			return nil, fmt.Errorf("having column %s not found", sqlparser.String(e))
+		}
+		index := p.index(ref, columns)
+		if index < 0 {
+			return nil, fmt.Errorf("having column %s not found in result columns", sqlparser.String(e))
+		}
+		return row[index], nil
+	case *sqlparser.SQLVal:
+		return sqlValue(e, args)
+	case *sqlparser.NullVal:
+		return nil, nil
+	case sqlparser.BoolVal:
+		return bool(e), nil
+	}
+	return nil, fmt.Errorf("having expression %s is not supported across data nodes", sqlparser.String(expr))
+}
+
+// evalCoalesce COALESCE、IFNULL 取第一个非 NULL 的参数
+func (p *mergePlan) evalCoalesce(fn *sqlparser.FuncExpr, refs map[string]columnRef, row []driver.Value, columns []string, args []interface{}) (driver.Value, error) {
+	for _, arg := range fn.Exprs {
+		aliased, ok := arg.(*sqlparser.AliasedExpr)
+		if !ok {
+			return nil, fmt.Errorf("expression %s is not supported across data nodes", sqlparser.String(fn))
+		}
+		value, err := p.evalExpr(aliased.Expr, refs, row, columns, args)
+		if err != nil || value != nil {
+			return value, err
+		}
+	}
+	return nil, nil
+}
+
+func (p *mergePlan) evalComparison(e *sqlparser.ComparisonExpr, refs map[string]columnRef, row []driver.Value, columns []string, args []interface{}) (driver.Value, error) {
+	left, err := p.evalExpr(e.Left, refs, row, columns, args)
+	if err != nil {
+		return nil, err
+	}
+	if e.Operator == sqlparser.InStr || e.Operator == sqlparser.NotInStr {
+		tuple, ok := e.Right.(sqlparser.ValTuple)
+		if !ok {
+			return nil, fmt.Errorf("having expression %s is not supported across data nodes", sqlparser.String(e))
+		}
+		if left == nil {
+			return false, nil
+		}
+		in := false
+		for _, expr := range tuple {
+			value, err := p.evalExpr(expr, refs, row, columns, args)
+			if err != nil {
+				return nil, err
+			}
+			if value != nil && compareValues(left, value) == 0 {
+				in = true
+				break
+			}
+		}
+		return in == (e.Operator == sqlparser.InStr), nil
+	}
+	right, err := p.evalExpr(e.Right, refs, row, columns, args)
+	if err != nil {
+		return nil, err
+	}
+	if left == nil || right == nil {
+		return false, nil
+	}
+	c := compareValues(left, right)
+	switch e.Operator {
+	case sqlparser.EqualStr:
+		return c == 0, nil
+	case sqlparser.NotEqualStr:
+		return c != 0, nil
+	case sqlparser.LessThanStr:
+		return c < 0, nil
+	case sqlparser.LessEqualStr:
+		return c <= 0, nil
+	case sqlparser.GreaterThanStr:
+		return c > 0, nil
+	case sqlparser.GreaterEqualStr:
+		return c >= 0, nil
+	}
+	return nil, fmt.Errorf("having operator %s is not supported across data nodes", e.Operator)
+}
+
+// sqlValue 常量或占位符的值
+func sqlValue(val *sqlparser.SQLVal, args []interface{}) (driver.Value, error) {
+	switch val.Type {
+	case sqlparser.StrVal:
+		return string(val.Val), nil
+	case sqlparser.IntVal:
+		return strconv.ParseInt(string(val.Val), 10, 64)
+	case sqlparser.FloatVal:
+		return strconv.ParseFloat(string(val.Val), 64)
+	case sqlparser.ValArg:
+		index, err := strconv.Atoi(strings.TrimPrefix(string(val.Val), ":v"))
+		if err != nil || index < 1 || index > len(args) {
+			return nil, fmt.Errorf("placeholder %s out of range", val.Val)
+		}
+		return driver.DefaultParameterConverter.ConvertValue(args[index-1])
+	}
+	return string(val.Val), nil
+}
+
+// arithmetic 四则运算，整数及定点小数的加、减、乘保持精确，其余按浮点数计算
+func arithmetic(operator string, left, right driver.Value) (driver.Value, error) {
+	if left == nil || right == nil {
+		return nil, nil
+	}
+	if operator != sqlparser.DivStr {
+		if value, ok := exactArithmetic(operator, left, right); ok {
+			return value, nil
+		}
+	}
+	x, ok := toFloat(left)
+	y, ok2 := toFloat(right)
+	if !ok || !ok2 {
+		return nil, fmt.Errorf("arithmetic on non-numeric values: %v %s %v", left, operator, right)
+	}
+	switch operator {
+	case sqlparser.PlusStr:
+		return x + y, nil
+	case sqlparser.MinusStr:
+		return x - y, nil
+	case sqlparser.MultStr:
+		return x * y, nil
+	case sqlparser.DivStr:
+		if y == 0 {
+			return nil, nil
+		}
+		return x / y, nil
+	}
+	return nil, fmt.Errorf("arithmetic operator %s is not supported across data nodes", operator)
+}
+
+// exactArithmetic 整数或定点小数的加、减、乘，整数溢出或含浮点数时返回 false
+func exactArithmetic(operator string, left, right driver.Value) (driver.Value, bool) {
+	x, xScale, ok := exactValue(left)
+	if !ok {
+		return nil, false
+	}
+	y, yScale, ok := exactValue(right)
+	if !ok {
+		return nil, false
+	}
+	result, scale := new(big.Rat), xScale
+	switch operator {
+	case sqlparser.PlusStr:
+		result.Add(x, y)
+	case sqlparser.MinusStr:
+		result.Sub(x, y)
+	case sqlparser.MultStr:
+		result.Mul(x, y)
+		scale = xScale + yScale
+	default:
+		return nil, false
+	}
+	if yScale > scale {
+		scale = yScale
+	}
+	if scale == 0 {
+		if result.IsInt() && result.Num().IsInt64() {
+			return result.Num().Int64(), true
+		}
+		return nil, false
+	}
+	return result.FloatString(scale), true
+}
+
+// exactValue 整数或定点小数的精确值及小数位数
+func exactValue(v driver.Value) (*big.Rat, int, bool) {
+	switch n := v.(type) {
+	case int64:
+		return new(big.Rat).SetInt64(n), 0, true
+	case []byte, string:
+		str := toString(v)
+		if i, err := strconv.ParseInt(str, 10, 64); err == nil {
+			return new(big.Rat).SetInt64(i), 0, true
+		}
+		return parseDecimal(str)
+	}
+	return nil, 0, false
+}
+
+// truthy 条件结果是否为真，数值非 0 为真
+func truthy(v driver.Value) bool {
+	switch b := v.(type) {
+	case nil:
+		return false
+	case bool:
+		return b
+	}
+	f, ok := toFloat(v)
+	return ok && f != 0
+}
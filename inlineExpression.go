@@ -0,0 +1,90 @@
+package dbroute
+
+import (
+	"fmt"
+	"strings"
+)
+
+// isInlineExpression 是否为行表达式，如 ds_${user_id % 4}、order_$->{order_id % 16}
+func isInlineExpression(expression string) bool {
+	return strings.Contains(expression, "${") || strings.Contains(expression, "$->{")
+}
+
+// translateInlineExpression
+//
+//	@Description: 将 Groovy 风格的行表达式转换为分片表达式：${} 外的文本为字符串常量，${} 内为分片表达式，
+//	ds_${user_id % 4} 转换为 parse("ds_", (user_id % 4))；仅有一个 ${} 时保留其计算结果，数值即为分片序号
+//	@param expression	行表达式，同时支持 ShardingSphere 的 $->{} 写法
+//	@return string
+//	@return error
+func translateInlineExpression(expression string) (string, error) {
+	var parts []string
+	literal := strings.Builder{}
+	runes := []rune(expression)
+	for i := 0; i < len(runes); {
+		start := 0
+		switch {
+		case strings.HasPrefix(string(runes[i:]), "${"):
+			start = i + 2
+		case strings.HasPrefix(string(runes[i:]), "$->{"):
+			start = i + 4
+		default:
+			literal.WriteRune(runes[i])
+			i++
+			continue
+		}
+		end, err := closingBrace(runes, start)
+		if err != nil {
+			return "", fmt.Errorf("inline expression %q: %w at %d", expression, err, i)
+		}
+		inner := strings.TrimSpace(string(runes[start:end]))
+		if inner == "" {
+			return "", fmt.Errorf("inline expression %q: empty ${} at %d", expression, i)
+		}
+		if literal.Len() > 0 {
+			parts = append(parts, quoteExpressionString(literal.String()))
+			literal.Reset()
+		}
+		parts = append(parts, "("+inner+")")
+		i = end + 1
+	}
+	if literal.Len() > 0 {
+		parts = append(parts, quoteExpressionString(literal.String()))
+	}
+	if len(parts) == 1 {
+		return parts[0], nil
+	}
+	return "parse(" + strings.Join(parts, ", ") + ")", nil
+}
+
+// closingBrace 查找与 ${ 匹配的 }，跳过嵌套的括号及字符串常量
+func closingBrace(runes []rune, start int) (int, error) {
+	depth := 0
+	var quote rune
+	for i := start; i < len(runes); i++ {
+		c := runes[i]
+		switch {
+		case quote != 0:
+			if c == '\\' {
+				i++
+			} else if c == quote {
+				quote = 0
+			}
+		case c == '"' || c == '\'':
+			quote = c
+		case c == '{':
+			depth++
+		case c == '}':
+			if depth == 0 {
+				return i, nil
+			}
+			depth--
+		}
+	}
+	return 0, fmt.Errorf("unclosed ${")
+}
+
+// quoteExpressionString 转换为分片表达式的字符串常量，反斜杠及引号需转义
+func quoteExpressionString(s string) string {
+	return `"` + strings.NewReplacer(`\`, `\\`, `"`, `\"`, `'`, `\'`).Replace(s) + `"`
+}
@@ -0,0 +1,82 @@
+package dbroute
+
+import "testing"
+
+func TestTranslateInlineExpression(t *testing.T) {
+	parameters := map[string]interface{}{"user_id": 7, "order_id": 21, "tenant_id": "a", "name": "hello"}
+	tests := []struct {
+		name       string
+		expression string
+		want       string
+		wantResult expressionResult
+		wantErr    bool
+	}{
+		{
+			name:       "groovy",
+			expression: "ds_${user_id % 4}",
+			want:       `parse("ds_", (user_id % 4))`,
+			wantResult: expressionResult{name: "ds_3"},
+		},
+		{
+			name:       "sharding sphere",
+			expression: "order_$->{order_id % 16}",
+			want:       `parse("order_", (order_id % 16))`,
+			wantResult: expressionResult{name: "order_5"},
+		},
+		{
+			name:       "index only",
+			expression: "${user_id % 4}",
+			want:       "(user_id % 4)",
+			wantResult: expressionResult{index: 3, isIndex: true},
+		},
+		{
+			name:       "multiple placeholders",
+			expression: "t_${tenant_id}_${user_id % 2}",
+			want:       `parse("t_", (tenant_id), "_", (user_id % 2))`,
+			wantResult: expressionResult{name: "t_a_1"},
+		},
+		{
+			name:       "brace inside string",
+			expression: `ds_${substr(name, 0, 2) + "}"}`,
+			want:       `parse("ds_", (substr(name, 0, 2) + "}"))`,
+			wantResult: expressionResult{name: "ds_he}"},
+		},
+		{
+			name:       "quotes escaped",
+			expression: `it's_${user_id % 2}`,
+			want:       `parse("it\'s_", (user_id % 2))`,
+			wantResult: expressionResult{name: "it's_1"},
+		},
+		{
+			name:       "unclosed",
+			expression: "ds_${user_id % 4",
+			wantErr:    true,
+		},
+		{
+			name:       "empty",
+			expression: "ds_${ }",
+			wantErr:    true,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, err := translateInlineExpression(tt.expression)
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("err = %v, wantErr %v", err, tt.wantErr)
+			}
+			if tt.wantErr {
+				return
+			}
+			if got != tt.want {
+				t.Errorf("expression = %s, want %s", got, tt.want)
+			}
+			result, err := evaluateExpression(got, parameters)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if result != tt.wantResult {
+				t.Errorf("result = %+v, want %+v", result, tt.wantResult)
+			}
+		})
+	}
+}
@@ -0,0 +1,221 @@
+package dbroute
+
+import (
+	"fmt"
+	"gorm.io/gorm"
+	"gorm.io/gorm/schema"
+	"reflect"
+	"strconv"
+	"sync"
+	"time"
+)
+
+const (
+	// GeneratedTag 由主键生成器生成的主键，如 `gorm:"primaryKey;generated"`
+	GeneratedTag = "GENERATED"
+
+	snowflakeWorkerBits   = 10
+	snowflakeSequenceBits = 12
+	// MaxSnowflakeWorkerID 最大工作节点号
+	MaxSnowflakeWorkerID = 1<<snowflakeWorkerBits - 1
+	// 时钟回拨不超过该时长时等待时钟追上，否则返回错误
+	maxClockBackward = 5 * time.Millisecond
+)
+
+// DefaultSnowflakeEpoch 默认起始时间
+var DefaultSnowflakeEpoch = time.Date(2020, 1, 1, 0, 0, 0, 0, time.UTC)
+
+// KeyGenerator 分布式主键生成器，生成的主键在全部数据节点中唯一
+type KeyGenerator interface {
+	NextID() (int64, error)
+}
+
+// GeneKeyGenerator 基因主键生成器，主键的低位为分片键的基因，按主键查询时可推算出分片键所在的分片
+type GeneKeyGenerator interface {
+	KeyGenerator
+	NextGeneID(gene int64) (int64, error)
+}
+
+// Gene 取值的低 bits 位
+func Gene(value int64, bits int) int64 {
+	return value & (1<<bits - 1)
+}
+
+// Snowflake 雪花算法主键生成器，由 41 位毫秒时间戳、10 位工作节点号及 12 位序列号组成，
+// 每个工作节点每毫秒最多生成 4096 个主键，不同进程须配置不同的工作节点号。
+// 配置基因位数时序列号的低位为基因，每毫秒可生成的主键相应减少
+type Snowflake struct {
+	mu       sync.Mutex
+	epoch    int64
+	workerID int64
+	geneBits int
+	lastTime int64
+	sequence int64
+}
+
+// NewSnowflake
+//
+//	@Description: 创建雪花算法主键生成器
+//	@param workerID	工作节点号，0 ~ MaxSnowflakeWorkerID
+//	@param epoch	起始时间，零值时使用 DefaultSnowflakeEpoch，创建后不可修改
+//	@return *Snowflake
+//	@return error
+func NewSnowflake(workerID int64, epoch time.Time) (*Snowflake, error) {
+	return NewGeneSnowflake(workerID, epoch, 0)
+}
+
+// NewGeneSnowflake
+//
+//	@Description: 创建基因雪花算法主键生成器，主键的低 geneBits 位为基因，如 order_id 的低位取自 user_id
+//	@param workerID	工作节点号，0 ~ MaxSnowflakeWorkerID
+//	@param epoch	起始时间，零值时使用 DefaultSnowflakeEpoch
+//	@param geneBits	基因位数，0 ~ 11，分片数须整除 2^geneBits
+//	@return *Snowflake
+//	@return error
+func NewGeneSnowflake(workerID int64, epoch time.Time, geneBits int) (*Snowflake, error) {
+	if workerID < 0 || workerID > MaxSnowflakeWorkerID {
+		return nil, fmt.Errorf("snowflake worker id %d out of range [0, %d]", workerID, MaxSnowflakeWorkerID)
+	}
+	if geneBits < 0 || geneBits >= snowflakeSequenceBits {
+		return nil, fmt.Errorf("snowflake gene bits %d out of range [0, %d]", geneBits, snowflakeSequenceBits-1)
+	}
+	if epoch.IsZero() {
+		epoch = DefaultSnowflakeEpoch
+	}
+	return &Snowflake{epoch: epoch.UnixMilli(), workerID: workerID, geneBits: geneBits, lastTime: -1}, nil
+}
+
+// NextID 生成主键，同一毫秒内序列号用尽时等待下一毫秒
+func (s *Snowflake) NextID() (int64, error) {
+	return s.NextGeneID(0)
+}
+
+// NextGeneID 生成低位为基因的主键，未配置基因位数时忽略基因
+func (s *Snowflake) NextGeneID(gene int64) (int64, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	now := s.now()
+	if now < s.lastTime {
+		backward := time.Duration(s.lastTime-now) * time.Millisecond
+		if backward > maxClockBackward {
+			return 0, fmt.Errorf("snowflake clock moved backwards by %v", backward)
+		}
+		time.Sleep(backward)
+		now = s.wait()
+	}
+	if now == s.lastTime {
+		s.sequence = (s.sequence + 1) & (1<<(snowflakeSequenceBits-s.geneBits) - 1)
+		if s.sequence == 0 {
+			now = s.wait()
+		}
+	} else {
+		s.sequence = 0
+	}
+	s.lastTime = now
+	return now<<(snowflakeWorkerBits+snowflakeSequenceBits) | s.workerID<<snowflakeSequenceBits | s.sequence<<s.geneBits | Gene(gene, s.geneBits), nil
+}
+
+func (s *Snowflake) now() int64 {
+	return time.Now().UnixMilli() - s.epoch
+}
+
+// wait 等待时钟超过上次生成的时间
+func (s *Snowflake) wait() int64 {
+	now := s.now()
+	for now <= s.lastTime {
+		time.Sleep(100 * time.Microsecond)
+		now = s.now()
+	}
+	return now
+}
+
+// generatedField 生成的主键及提供基因的列
+type generatedField struct {
+	field *schema.Field
+	gene  *schema.Field
+}
+
+// generateKeys
+//
+//	@Description: 插入前为标记 generated 的零值主键生成主键，在构建插入语句及 BeforeCreate 等钩子之前执行；
+//	标记为 generated:user_id 时以该列取值的低位作为主键的基因，需配置 GeneKeyGenerator，基因列须在 Create 前赋值，为零值时返回错误
+//	@param db
+func (dr *DBRoute) generateKeys(db *gorm.DB) {
+	stmt := db.Statement
+	if db.Error != nil || stmt.Schema == nil {
+		return
+	}
+	var fields []generatedField
+	for _, field := range stmt.Schema.PrimaryFields {
+		setting, ok := field.TagSettings[GeneratedTag]
+		if !ok {
+			continue
+		}
+		generated := generatedField{field: field}
+		if setting != GeneratedTag {
+			if generated.gene = stmt.Schema.LookUpField(setting); generated.gene == nil {
+				db.AddError(fmt.Errorf("gene column %s of %s not found", setting, field.Name))
+				return
+			}
+		}
+		fields = append(fields, generated)
+	}
+	if len(fields) == 0 {
+		return
+	}
+	r := dr.lookupRoute(stmt)
+	if r == nil || r.keyGenerator == nil {
+		db.AddError(fmt.Errorf("no key generator configured for table %s", stmt.Table))
+		return
+	}
+	generate := func(rv reflect.Value) {
+		if reflect.Indirect(rv).Kind() != reflect.Struct {
+			return
+		}
+		for _, generated := range fields {
+			field := generated.field
+			if _, isZero := field.ValueOf(stmt.Context, rv); !isZero {
+				continue
+			}
+			id, err := r.nextID(stmt, rv, generated.gene)
+			if err != nil {
+				db.AddError(err)
+				return
+			}
+			if field.FieldType.Kind() == reflect.String {
+				db.AddError(field.Set(stmt.Context, rv, strconv.FormatInt(id, 10)))
+			} else {
+				db.AddError(field.Set(stmt.Context, rv, id))
+			}
+		}
+	}
+	switch stmt.ReflectValue.Kind() {
+	case reflect.Slice, reflect.Array:
+		for i := 0; i < stmt.ReflectValue.Len(); i++ {
+			generate(stmt.ReflectValue.Index(i))
+		}
+	case reflect.Struct:
+		generate(stmt.ReflectValue)
+	}
+}
+
+// nextID 生成主键，gene 不为空时以该列的取值作为基因
+func (r *route) nextID(stmt *gorm.Statement, rv reflect.Value, gene *schema.Field) (int64, error) {
+	if gene == nil {
+		return r.keyGenerator.NextID()
+	}
+	generator, ok := r.keyGenerator.(GeneKeyGenerator)
+	if !ok {
+		return 0, fmt.Errorf("key generator of table %s does not support gene", stmt.Table)
+	}
+	value, isZero := gene.ValueOf(stmt.Context, rv)
+	if isZero {
+		// 主键在 BeforeCreate 等钩子之前生成，钩子中设置的基因列此时尚未赋值
+		return 0, fmt.Errorf("gene column %s of table %s is zero, it must be set before Create rather than in BeforeCreate hooks", gene.DBName, stmt.Table)
+	}
+	geneValue, err := toInt64(value)
+	if err != nil {
+		return 0, fmt.Errorf("gene column %s: %w", gene.DBName, err)
+	}
+	return generator.NextGeneID(geneValue)
+}
@@ -0,0 +1,186 @@
+package dbroute
+
+import (
+	"fmt"
+	"testing"
+	"time"
+)
+
+func TestSnowflake(t *testing.T) {
+	epoch := time.Now().Add(-time.Hour)
+	tests := []struct {
+		name     string
+		workerID int64
+		geneBits int
+	}{
+		{name: "plain", workerID: 0},
+		{name: "max worker", workerID: MaxSnowflakeWorkerID},
+		{name: "gene", workerID: 7, geneBits: 2},
+		{name: "max gene bits", workerID: 1, geneBits: snowflakeSequenceBits - 1},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			s, err := NewGeneSnowflake(tt.workerID, epoch, tt.geneBits)
+			if err != nil {
+				t.Fatal(err)
+			}
+			seen := make(map[int64]bool)
+			var last int64
+			for i := int64(0); i < 1000; i++ {
+				id, err := s.NextGeneID(i)
+				if err != nil {
+					t.Fatal(err)
+				}
+				if seen[id] || id <= last {
+					t.Fatalf("id %d not unique and increasing after %d", id, last)
+				}
+				seen[id], last = true, id
+				if worker := id >> snowflakeSequenceBits & MaxSnowflakeWorkerID; worker != tt.workerID {
+					t.Fatalf("worker of %d = %d, want %d", id, worker, tt.workerID)
+				}
+				if gene := Gene(id, tt.geneBits); gene != Gene(i, tt.geneBits) {
+					t.Fatalf("gene of %d = %d, want %d", id, gene, Gene(i, tt.geneBits))
+				}
+				millis := id >> (snowflakeWorkerBits + snowflakeSequenceBits)
+				if created := time.UnixMilli(epoch.UnixMilli() + millis); time.Since(created) < 0 || time.Since(created) > time.Minute {
+					t.Fatalf("time of %d = %v", id, created)
+				}
+			}
+		})
+	}
+}
+
+func TestNewGeneSnowflakeInvalid(t *testing.T) {
+	tests := []struct {
+		name     string
+		workerID int64
+		geneBits int
+	}{
+		{name: "negative worker", workerID: -1},
+		{name: "worker overflow", workerID: MaxSnowflakeWorkerID + 1},
+		{name: "negative gene bits", geneBits: -1},
+		{name: "gene bits use up sequence", geneBits: snowflakeSequenceBits},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if _, err := NewGeneSnowflake(tt.workerID, time.Time{}, tt.geneBits); err == nil {
+				t.Error("want error")
+			}
+		})
+	}
+}
+
+// TestGeneShardingConditions 仅按基因主键查询时路由到分片键所在的分片
+func TestGeneShardingConditions(t *testing.T) {
+	s, err := NewGeneSnowflake(1, time.Time{}, 2)
+	if err != nil {
+		t.Fatal(err)
+	}
+	rule := DataShardingRuleModel{
+		Table:                  "order",
+		TableShardingParameter: "user_id",
+		TableShardingAlgorithm: &ShardingAlgorithmConfig{Type: AlgorithmMod, Props: ShardingAlgorithmProps{ShardingCount: 4}},
+		GeneParameter:          "order_id",
+		GeneBits:               2,
+	}
+	if err := rule.validate(); err != nil {
+		t.Fatal(err)
+	}
+	for _, userID := range []int64{1, 6, 11, 100} {
+		t.Run(fmt.Sprint(userID), func(t *testing.T) {
+			orderID, err := s.NextGeneID(userID)
+			if err != nil {
+				t.Fatal(err)
+			}
+			want := fmt.Sprintf("order_%d", userID%4)
+			tests := []struct {
+				name string
+				sql  string
+				want []string
+			}{
+				{name: "by order id", sql: fmt.Sprintf("SELECT * FROM `order` WHERE order_id = %d", orderID), want: []string{want}},
+				{name: "by user id", sql: fmt.Sprintf("SELECT * FROM `order` WHERE user_id = %d", userID), want: []string{want}},
+				{name: "user id preferred", sql: fmt.Sprintf("SELECT * FROM `order` WHERE order_id = %d AND user_id = 3", orderID), want: []string{"order_3"}},
+				{name: "order id range", sql: fmt.Sprintf("SELECT * FROM `order` WHERE order_id > %d", orderID)},
+			}
+			for _, tt := range tests {
+				t.Run(tt.name, func(t *testing.T) {
+					conds, err := rule.shardingConditions(tt.sql, rule.tableShardingParameters())
+					if err != nil {
+						t.Fatal(err)
+					}
+					got, ok, err := conds.targets(rule.tableSharding())
+					if err != nil {
+						t.Fatal(err)
+					}
+					if ok != (tt.want != nil) || fmt.Sprint(got) != fmt.Sprint(tt.want) {
+						t.Errorf("targets = %v, %v, want %v", got, ok, tt.want)
+					}
+				})
+			}
+		})
+	}
+}
+
+// TestValidateGene 基因分片仅支持分片数整除 2^GeneBits 的 MOD 算法
+func TestValidateGene(t *testing.T) {
+	mod := func(count int64) *ShardingAlgorithmConfig {
+		return &ShardingAlgorithmConfig{Type: AlgorithmMod, Props: ShardingAlgorithmProps{ShardingCount: count}}
+	}
+	tests := []struct {
+		name    string
+		rule    DataShardingRuleModel
+		wantErr bool
+	}{
+		{
+			name: "mod divides gene",
+			rule: DataShardingRuleModel{TableShardingParameter: "user_id", TableShardingAlgorithm: mod(4), GeneBits: 2},
+		},
+		{
+			name: "database and table mod",
+			rule: DataShardingRuleModel{DatabaseShardingParameter: "user_id", DatabaseShardingAlgorithm: mod(2),
+				TableShardingParameter: "user_id", TableShardingAlgorithm: mod(4), GeneBits: 2},
+		},
+		{
+			name:    "mod not dividing gene",
+			rule:    DataShardingRuleModel{TableShardingParameter: "user_id", TableShardingAlgorithm: mod(3), GeneBits: 2},
+			wantErr: true,
+		},
+		{
+			name:    "mod larger than gene",
+			rule:    DataShardingRuleModel{TableShardingParameter: "user_id", TableShardingAlgorithm: mod(8), GeneBits: 2},
+			wantErr: true,
+		},
+		{
+			name:    "expression",
+			rule:    DataShardingRuleModel{TableShardingParameter: "user_id", TableShardingExpression: "order_${user_id % 4}", GeneBits: 2},
+			wantErr: true,
+		},
+		{
+			name: "hash mod",
+			rule: DataShardingRuleModel{TableShardingParameter: "user_id", GeneBits: 2,
+				TableShardingAlgorithm: &ShardingAlgorithmConfig{Type: AlgorithmHashMod, Props: ShardingAlgorithmProps{ShardingCount: 4}}},
+			wantErr: true,
+		},
+		{
+			name: "database expression",
+			rule: DataShardingRuleModel{DatabaseShardingParameter: "user_id", DatabaseShardingExpression: "ds_${user_id % 2}",
+				TableShardingParameter: "user_id", TableShardingAlgorithm: mod(4), GeneBits: 2},
+			wantErr: true,
+		},
+		{
+			name:    "no gene bits",
+			rule:    DataShardingRuleModel{TableShardingParameter: "user_id", TableShardingAlgorithm: mod(4)},
+			wantErr: true,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			tt.rule.Table = "order"
+			tt.rule.GeneParameter = "order_id"
+			if err := tt.rule.validate(); (err != nil) != tt.wantErr {
+				t.Errorf("validate() error = %v, wantErr %v", err, tt.wantErr)
+			}
+		})
+	}
+}
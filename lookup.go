@@ -0,0 +1,400 @@
+package dbroute
+
+import (
+	"container/list"
+	"context"
+	"fmt"
+	"github.com/xwb1989/sqlparser"
+	"gorm.io/gorm"
+	"gorm.io/gorm/clause"
+	"gorm.io/gorm/logger"
+	"strings"
+	"sync"
+	"time"
+)
+
+const (
+	// DefaultLookupTTL 目录缓存默认有效期
+	DefaultLookupTTL = time.Minute
+	// DefaultLookupCapacity 目录缓存默认容量
+	DefaultLookupCapacity = 10000
+)
+
+// LookupDirectory 分片目录，分片键取值到分片名（数据源名或物理表名）的映射保存在指定数据源的映射表中，
+// 查询结果在进程内按 TTL 与 LRU 缓存。DB 应为未注册路由插件的连接，避免映射表查询被路由
+type LookupDirectory struct {
+	DB *gorm.DB
+	// Table 映射表
+	Table string
+	// KeyColumn、ShardColumn 映射表中分片键与分片名的列，默认 sharding_key、shard，分片键须有唯一约束
+	KeyColumn   string
+	ShardColumn string
+	// TTL 缓存有效期，默认 DefaultLookupTTL
+	TTL time.Duration
+	// Capacity 缓存容量，默认 DefaultLookupCapacity
+	Capacity int
+
+	once  sync.Once
+	cache *lookupCache
+}
+
+func (d *LookupDirectory) init() {
+	d.once.Do(func() {
+		if d.KeyColumn == "" {
+			d.KeyColumn = "sharding_key"
+		}
+		if d.ShardColumn == "" {
+			d.ShardColumn = "shard"
+		}
+		if d.TTL <= 0 {
+			d.TTL = DefaultLookupTTL
+		}
+		if d.Capacity <= 0 {
+			d.Capacity = DefaultLookupCapacity
+		}
+		d.cache = &lookupCache{ttl: d.TTL, capacity: d.Capacity, ll: list.New(), items: map[string]*list.Element{}}
+	})
+}
+
+// Lookup
+//
+//	@Description: 查询分片键对应的分片，未登记时 found 为 false，未登记的结果同样缓存
+//	@param ctx
+//	@param key
+//	@return shard
+//	@return found
+//	@return err
+func (d *LookupDirectory) Lookup(ctx context.Context, key string) (shard string, found bool, err error) {
+	d.init()
+	if shard, found, ok := d.cache.get(key); ok {
+		return shard, found, nil
+	}
+	var shards []string
+	err = d.DB.WithContext(ctx).Table(d.Table).
+		Where(clause.Eq{Column: clause.Column{Name: d.KeyColumn}, Value: key}).
+		Limit(1).Pluck(d.ShardColumn, &shards).Error
+	if err != nil {
+		return "", false, err
+	}
+	if len(shards) > 0 {
+		shard, found = shards[0], true
+	}
+	d.cache.set(key, shard, found)
+	return shard, found, nil
+}
+
+// Register
+//
+//	@Description: 登记分片键对应的分片，已登记时保留原映射，返回最终生效的分片
+//	@param ctx
+//	@param key
+//	@param shard
+//	@return string
+//	@return error
+func (d *LookupDirectory) Register(ctx context.Context, key string, shard string) (string, error) {
+	d.init()
+	err := d.DB.WithContext(ctx).Table(d.Table).Clauses(clause.OnConflict{DoNothing: true}).
+		Create(map[string]interface{}{d.KeyColumn: key, d.ShardColumn: shard}).Error
+	if err != nil {
+		return "", err
+	}
+	// 并发登记时以映射表中的记录为准
+	d.cache.delete(key)
+	registered, found, err := d.Lookup(ctx, key)
+	if err != nil || !found {
+		return shard, err
+	}
+	return registered, nil
+}
+
+// Invalidate 清除分片键的缓存，手工调整映射后调用
+func (d *LookupDirectory) Invalidate(keys ...string) {
+	d.init()
+	for _, key := range keys {
+		d.cache.delete(key)
+	}
+}
+
+// lookupRegistrationsKey 上下文中待登记到目录的分片键，写语句执行成功后登记
+const lookupRegistrationsKey routeContextKey = "gorm:db_route:lookup"
+
+// lookupRegistration 待登记的分片键及按兜底规则计算的分片
+type lookupRegistration struct {
+	directory *LookupDirectory
+	key       string
+	shard     string
+}
+
+// lookupRegistrations 一条写语句中待登记的分片键，插入拆分到多个节点时由各行的路由并发写入
+type lookupRegistrations struct {
+	mu    sync.Mutex
+	items []lookupRegistration
+}
+
+func (r *lookupRegistrations) add(registration lookupRegistration) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	r.items = append(r.items, registration)
+}
+
+// take 取出全部待登记的分片键并清空
+func (r *lookupRegistrations) take() []lookupRegistration {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	items := r.items
+	r.items = nil
+	return items
+}
+
+// withLookupRegistrations 为写语句的上下文附加待登记分片键的收集器，已附加时沿用
+func withLookupRegistrations(ctx context.Context) context.Context {
+	if _, ok := ctx.Value(lookupRegistrationsKey).(*lookupRegistrations); ok {
+		return ctx
+	}
+	return context.WithValue(ctx, lookupRegistrationsKey, &lookupRegistrations{})
+}
+
+// registerLookupKeys
+//
+//	@Description: 写语句执行成功（含默认事务提交）后将插入的未登记分片键登记到目录，执行失败时丢弃；
+//	已被并发登记到其它分片时返回错误，此时数据已写入按兜底规则计算的分片
+//	@param db
+func (dr *DBRoute) registerLookupKeys(db *gorm.DB) {
+	pending, ok := db.Statement.Context.Value(lookupRegistrationsKey).(*lookupRegistrations)
+	if !ok {
+		return
+	}
+	registrations := pending.take()
+	if db.Error != nil {
+		return
+	}
+	for _, registration := range registrations {
+		registered, err := registration.directory.Register(db.Statement.Context, registration.key, registration.shard)
+		if err != nil {
+			db.AddError(fmt.Errorf("register sharding key %s: %w", registration.key, err))
+		} else if registered != registration.shard {
+			db.AddError(fmt.Errorf("sharding key %s is registered to %s, written to %s", registration.key, registered, registration.shard))
+		}
+	}
+}
+
+// targets
+//
+//	@Description: 按目录计算分片键各取值组合命中的分片，未登记的取值按 fallback 计算，插入语句在执行成功后将其登记到目录
+//	@param ctx
+//	@param log
+//	@param sql	填充了参数值的sql
+//	@param parameters	分片键，多列时按顺序以逗号拼接为目录中的键
+//	@param fallback	未登记取值的分片规则，为空时无法确定分片
+//	@return []string
+//	@return bool	存在无法确定的取值时返回 false
+//	@return error	查询目录失败或兜底规则无法计算分片时返回错误
+func (d *LookupDirectory) targets(ctx context.Context, log logger.Interface, sql string, parameters []string, fallback shardingFunc) ([]string, bool, error) {
+	conds, err := GetSqlShardingConditions(sql, parameters...)
+	if err != nil {
+		return nil, false, err
+	}
+	pending, _ := ctx.Value(lookupRegistrationsKey).(*lookupRegistrations)
+	insert := pending != nil && sqlparser.Preview(sql) == sqlparser.StmtInsert
+	unknown := false
+	targets, ok, err := conds.targets(func(values map[string]interface{}) (string, error) {
+		keys := make([]string, len(parameters))
+		for i, parameter := range parameters {
+			keys[i] = toString(values[parameter])
+		}
+		key := strings.Join(keys, ",")
+		shard, found, err := d.Lookup(ctx, key)
+		if err != nil {
+			return "", fmt.Errorf("lookup sharding key %s: %w", key, err)
+		}
+		if found {
+			return shard, nil
+		}
+		if fallback == nil {
+			unknown = true
+			return "", nil
+		}
+		if shard, err = fallback(values); err != nil {
+			return "", err
+		}
+		if insert {
+			pending.add(lookupRegistration{directory: d, key: key, shard: shard})
+		}
+		return shard, nil
+	})
+	return targets, ok && !unknown, err
+}
+
+// DbLookupPolicy 目录分库，分片键取值对应的数据源由目录给出，未登记的取值按规则中的分库表达式或算法计算
+type DbLookupPolicy struct {
+	// 需要操作分库分表，使用其中的分库键及分库规则
+	DataShardingRuleModelMap map[string]DataShardingRuleModel
+	Directory                *LookupDirectory
+}
+
+func (p *DbLookupPolicy) validate() error {
+	if p.Directory == nil {
+		return fmt.Errorf("lookup directory not configured")
+	}
+	return validateRules(p.DataShardingRuleModelMap)
+}
+
+// Resolve
+//
+//	@Description: 按目录解析数据源，sql为填充了参数值的sql
+func (p *DbLookupPolicy) Resolve(ctx context.Context, connPoolsMap map[ShardingName][]gorm.ConnPool, tableName string, sql string, log logger.Interface) (result DbPolicyResult) {
+	model, ok := p.DataShardingRuleModelMap[tableName]
+	parameters := model.databaseShardingParameters()
+	if !ok || len(parameters) == 0 {
+		// 不存在，走随机路由
+		return DbRandomPolicy{}.Resolve(ctx, connPoolsMap, tableName, sql, log)
+	}
+	var fallback shardingFunc
+	if model.DatabaseShardingExpression != "" || model.DatabaseShardingAlgorithm != nil {
+		fallback = model.databaseSharding(shardingNames(connPoolsMap))
+	}
+	targets, ok, err := p.Directory.targets(ctx, log, sql, parameters, fallback)
+	if err != nil {
+		return DbPolicyResult{Error: fmt.Errorf("database lookup of %s: %w", tableName, err)}
+	}
+	if !ok {
+		// 无法确定分库，分散到全部数据源
+		result = scatterPolicyResult(connPoolsMap)
+		log.Info(ctx, "database scatter: %v", len(result.Scatter))
+		return result
+	}
+	log.Info(ctx, "database lookup: %v", targets)
+	names := make([]ShardingName, len(targets))
+	for i, target := range targets {
+		names[i] = ShardingName(target)
+	}
+	if err = checkShardingNames(connPoolsMap, names...); err != nil {
+		return DbPolicyResult{Error: fmt.Errorf("database lookup of %s: %w", tableName, err)}
+	}
+	if len(targets) > 1 {
+		return scatterPolicyResult(connPoolsMap, names...)
+	}
+	return randomConnPool(ShardingName(targets[0]), connPoolsMap[ShardingName(targets[0])])
+}
+
+// TbLookupPolicy 目录分表，分片键取值对应的物理表由目录给出，未登记的取值按规则中的分表表达式或算法计算
+type TbLookupPolicy struct {
+	// 需要操作分库分表，使用其中的分表键、分表规则及全部物理表
+	DataShardingRuleModelMap map[string]DataShardingRuleModel
+	Directory                *LookupDirectory
+}
+
+func (p *TbLookupPolicy) validate() error {
+	if p.Directory == nil {
+		return fmt.Errorf("lookup directory not configured")
+	}
+	return validateRules(p.DataShardingRuleModelMap)
+}
+
+// validateLookupDirectories 分库与分表的目录中分片键相同而分片名含义不同，不能共用同一目录
+func validateLookupDirectories(configs []Config) error {
+	dbDirectories := make(map[*LookupDirectory]bool)
+	for _, config := range configs {
+		if policy, ok := config.DbPolicy.(*DbLookupPolicy); ok && policy.Directory != nil {
+			dbDirectories[policy.Directory] = true
+		}
+	}
+	for _, config := range configs {
+		if policy, ok := config.TbPolicy.(*TbLookupPolicy); ok && dbDirectories[policy.Directory] {
+			return fmt.Errorf("route of %v: lookup directory %s is shared by database and table lookup policies", config.tables, policy.Directory.Table)
+		}
+	}
+	return nil
+}
+
+// Resolve
+//
+//	@Description: 按目录解析物理表，sql为填充了参数值的sql
+func (p *TbLookupPolicy) Resolve(ctx context.Context, tableName string, sql string, log logger.Interface) (result TbPolicyResult) {
+	model, ok := p.DataShardingRuleModelMap[tableName]
+	parameters := model.tableShardingParameters()
+	if !ok || len(parameters) == 0 {
+		return TbPolicyResult{}
+	}
+	var fallback shardingFunc
+	if model.TableShardingExpression != "" || model.TableShardingAlgorithm != nil {
+		fallback = model.tableSharding()
+	}
+	targets, ok, err := p.Directory.targets(ctx, log, sql, parameters, fallback)
+	if err != nil {
+		return TbPolicyResult{Error: fmt.Errorf("table lookup of %s: %w", tableName, err)}
+	}
+	if !ok {
+		// 无法确定分表，分散到全部物理表
+		return scatterTables(ctx, log, tableName, model.ActualTables)
+	}
+	log.Info(ctx, "table lookup: %v", targets)
+	if len(targets) > 1 {
+		return TbPolicyResult{ActualTableName: targets[0], Scatter: targets}
+	}
+	return TbPolicyResult{ActualTableName: targets[0]}
+}
+
+// ActualTables 分表规则中配置的全部物理表
+func (p *TbLookupPolicy) ActualTables(tableName string) []string {
+	return p.DataShardingRuleModelMap[tableName].ActualTables
+}
+
+// lookupCache 目录缓存，超过容量时淘汰最久未使用的键
+type lookupCache struct {
+	mu       sync.Mutex
+	ttl      time.Duration
+	capacity int
+	ll       *list.List
+	items    map[string]*list.Element
+}
+
+type lookupEntry struct {
+	key     string
+	shard   string
+	found   bool
+	expires time.Time
+}
+
+func (c *lookupCache) get(key string) (string, bool, bool) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	element, ok := c.items[key]
+	if !ok {
+		return "", false, false
+	}
+	entry := element.Value.(*lookupEntry)
+	if time.Now().After(entry.expires) {
+		c.ll.Remove(element)
+		delete(c.items, key)
+		return "", false, false
+	}
+	c.ll.MoveToFront(element)
+	return entry.shard, entry.found, true
+}
+
+func (c *lookupCache) set(key string, shard string, found bool) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	entry := &lookupEntry{key: key, shard: shard, found: found, expires: time.Now().Add(c.ttl)}
+	if element, ok := c.items[key]; ok {
+		element.Value = entry
+		c.ll.MoveToFront(element)
+		return
+	}
+	c.items[key] = c.ll.PushFront(entry)
+	for c.ll.Len() > c.capacity {
+		oldest := c.ll.Back()
+		c.ll.Remove(oldest)
+		delete(c.items, oldest.Value.(*lookupEntry).key)
+	}
+}
+
+func (c *lookupCache) delete(key string) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if element, ok := c.items[key]; ok {
+		c.ll.Remove(element)
+		delete(c.items, key)
+	}
+}
@@ -0,0 +1,175 @@
+package dbroute
+
+import (
+	"container/list"
+	"context"
+	"database/sql"
+	"database/sql/driver"
+	"errors"
+	"reflect"
+	"testing"
+	"time"
+
+	"gorm.io/gorm"
+	"gorm.io/gorm/logger"
+)
+
+// testLookupPool 内存中的映射表，记录查询次数
+type testLookupPool struct {
+	testConnPool
+	shards  map[string]string
+	queries int
+}
+
+// ExecContext 登记映射，参数按列名排序为 shard、sharding_key，已登记时保留原映射
+func (p *testLookupPool) ExecContext(_ context.Context, _ string, args ...interface{}) (sql.Result, error) {
+	shard, key := args[0].(string), args[1].(string)
+	if _, ok := p.shards[key]; !ok {
+		p.shards[key] = shard
+	}
+	return testInsertResult(0), nil
+}
+
+func (p *testLookupPool) QueryContext(ctx context.Context, _ string, args ...interface{}) (*sql.Rows, error) {
+	p.queries++
+	rs := &resultSet{columns: []string{"shard"}}
+	if shard, ok := p.shards[args[0].(string)]; ok {
+		rs.rows = append(rs.rows, []driver.Value{shard})
+	}
+	return resultSetDB().QueryContext(ctx, "", rs)
+}
+
+// testLookupDirectory 映射表位于 testLookupPool 的目录
+func testLookupDirectory(t *testing.T, shards map[string]string) (*LookupDirectory, *testLookupPool) {
+	pool := &testLookupPool{shards: shards}
+	db, err := gorm.Open(testDialector{pool: pool}, &gorm.Config{SkipDefaultTransaction: true, Logger: logger.Discard})
+	if err != nil {
+		t.Fatal(err)
+	}
+	return &LookupDirectory{DB: db, Table: "order_directory"}, pool
+}
+
+func TestLookupCache(t *testing.T) {
+	t.Run("ttl expiry", func(t *testing.T) {
+		c := &lookupCache{ttl: time.Minute, capacity: 2, ll: list.New(), items: map[string]*list.Element{}}
+		c.set("1", "ds_0", true)
+		if shard, found, ok := c.get("1"); !ok || !found || shard != "ds_0" {
+			t.Fatalf("get() = %s, %v, %v", shard, found, ok)
+		}
+		c.items["1"].Value.(*lookupEntry).expires = time.Now().Add(-time.Second)
+		if _, _, ok := c.get("1"); ok {
+			t.Error("expired entry returned")
+		}
+		if c.ll.Len() != 0 || len(c.items) != 0 {
+			t.Errorf("expired entry kept, len = %d", c.ll.Len())
+		}
+	})
+	t.Run("lru eviction", func(t *testing.T) {
+		c := &lookupCache{ttl: time.Minute, capacity: 2, ll: list.New(), items: map[string]*list.Element{}}
+		c.set("1", "ds_0", true)
+		c.set("2", "ds_1", true)
+		c.get("1")
+		c.set("3", "ds_0", true)
+		if _, _, ok := c.get("2"); ok {
+			t.Error("least recently used key not evicted")
+		}
+		for _, key := range []string{"1", "3"} {
+			if _, _, ok := c.get(key); !ok {
+				t.Errorf("key %s evicted", key)
+			}
+		}
+	})
+	t.Run("negative entry", func(t *testing.T) {
+		c := &lookupCache{ttl: time.Minute, capacity: 2, ll: list.New(), items: map[string]*list.Element{}}
+		c.set("1", "", false)
+		if shard, found, ok := c.get("1"); !ok || found || shard != "" {
+			t.Errorf("get() = %s, %v, %v, want cached not found", shard, found, ok)
+		}
+	})
+}
+
+func TestLookupDirectoryLookup(t *testing.T) {
+	d, pool := testLookupDirectory(t, map[string]string{"1": "ds_1"})
+	for i := 0; i < 2; i++ {
+		shard, found, err := d.Lookup(context.Background(), "1")
+		if err != nil || !found || shard != "ds_1" {
+			t.Fatalf("Lookup(1) = %s, %v, %v", shard, found, err)
+		}
+		// 未登记的结果同样缓存
+		if _, found, err = d.Lookup(context.Background(), "2"); err != nil || found {
+			t.Fatalf("Lookup(2) found = %v, %v", found, err)
+		}
+	}
+	if pool.queries != 2 {
+		t.Errorf("queries = %d, want 2", pool.queries)
+	}
+	pool.shards["2"] = "ds_0"
+	d.Invalidate("2")
+	if shard, found, _ := d.Lookup(context.Background(), "2"); !found || shard != "ds_0" {
+		t.Errorf("Lookup(2) after invalidate = %s, %v", shard, found)
+	}
+}
+
+// TestRegisterLookupKeys 插入成功后登记未登记的分片键并写入缓存，执行失败时丢弃
+func TestRegisterLookupKeys(t *testing.T) {
+	tests := []struct {
+		name string
+		// 路由后、登记前由其它进程登记的分片
+		concurrent string
+		execErr    error
+		wantErr    bool
+		wantShards map[string]string
+	}{
+		{name: "registered after insert", wantShards: map[string]string{"7": "ds_1"}},
+		{name: "discarded on failure", execErr: errors.New("exec"), wantErr: true, wantShards: map[string]string{}},
+		{name: "registered concurrently to other shard", concurrent: "ds_0", wantErr: true, wantShards: map[string]string{"7": "ds_0"}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			d, pool := testLookupDirectory(t, map[string]string{})
+			ctx := withLookupRegistrations(context.Background())
+			fallback := func(map[string]interface{}) (string, error) { return "ds_1", nil }
+			targets, ok, err := d.targets(ctx, logger.Discard, "INSERT INTO `order` (user_id) VALUES (7)", []string{"user_id"}, fallback)
+			if err != nil || !ok || !reflect.DeepEqual(targets, []string{"ds_1"}) {
+				t.Fatalf("targets() = %v, %v, %v", targets, ok, err)
+			}
+			if tt.concurrent != "" {
+				pool.shards["7"] = tt.concurrent
+			}
+			db := &gorm.DB{Config: &gorm.Config{}, Statement: &gorm.Statement{Context: ctx}}
+			db.Error = tt.execErr
+			(&DBRoute{}).registerLookupKeys(db)
+			if (db.Error != nil) != tt.wantErr {
+				t.Fatalf("registerLookupKeys() error = %v, wantErr %v", db.Error, tt.wantErr)
+			}
+			if !reflect.DeepEqual(pool.shards, tt.wantShards) {
+				t.Errorf("shards = %v, want %v", pool.shards, tt.wantShards)
+			}
+			queries := pool.queries
+			shard, found, err := d.Lookup(context.Background(), "7")
+			if err != nil || found != (len(tt.wantShards) > 0) || shard != tt.wantShards["7"] {
+				t.Errorf("Lookup(7) = %s, %v, %v, want %s", shard, found, err, tt.wantShards["7"])
+			}
+			if pool.queries != queries {
+				t.Error("lookup after registration not served from cache")
+			}
+		})
+	}
+}
+
+// TestLookupTargetsSelect 查询语句中未登记的分片键按兜底规则路由但不登记
+func TestLookupTargetsSelect(t *testing.T) {
+	d, _ := testLookupDirectory(t, map[string]string{"1": "ds_0"})
+	ctx := withLookupRegistrations(context.Background())
+	fallback := func(map[string]interface{}) (string, error) { return "ds_1", nil }
+	targets, ok, err := d.targets(ctx, logger.Discard, "SELECT * FROM `order` WHERE user_id IN (1, 2)", []string{"user_id"}, fallback)
+	if err != nil || !ok || !reflect.DeepEqual(targets, []string{"ds_0", "ds_1"}) {
+		t.Fatalf("targets() = %v, %v, %v", targets, ok, err)
+	}
+	if pending := ctx.Value(lookupRegistrationsKey).(*lookupRegistrations).take(); len(pending) != 0 {
+		t.Errorf("pending registrations = %v", pending)
+	}
+	if _, ok, _ = d.targets(ctx, logger.Discard, "SELECT * FROM `order` WHERE user_id = 2", []string{"user_id"}, nil); ok {
+		t.Error("unregistered key without fallback determined")
+	}
+}
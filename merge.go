@@ -0,0 +1,867 @@
+package dbroute
+
+import (
+	"bytes"
+	"container/heap"
+	"database/sql/driver"
+	"fmt"
+	"github.com/xwb1989/sqlparser"
+	"math/big"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+)
+
+const (
+	orderByDerivedAlias     = "ORDER_BY_DERIVED_%d"
+	groupByDerivedAlias     = "GROUP_BY_DERIVED_%d"
+	havingDerivedAlias      = "HAVING_DERIVED_%d"
+	avgSumDerivedAlias      = "AVG_DERIVED_SUM_%d"
+	avgCountDerivedAlias    = "AVG_DERIVED_COUNT_%d"
+	aggregationDerivedAlias = "AGGREGATION_DERIVED_%d"
+)
+
+// mergePlan 分散查询结果归并计划
+type mergePlan struct {
+	// 排序项
+	orderBy []orderByItem
+	// 分组列，分组或含聚合列时在内存中重新分组
+	groupBy []columnRef
+	grouped bool
+	// SELECT DISTINCT 跨节点去重
+	distinct bool
+	// 聚合列
+	aggregations []aggregationItem
+	// 含聚合函数的表达式列，如 COALESCE(SUM(x), 0)、SUM(a) / COUNT(b)，聚合后按表达式重新计算
+	computed []computedItem
+	// 归并后执行的 HAVING 条件及其引用的列
+	having     sqlparser.Expr
+	havingRefs map[string]columnRef
+	// 改写后语句中各占位符对应的原参数序号，HAVING 移至归并阶段、排序或分组及 HAVING 中的表达式复制到查询列后与原顺序不同
+	shardArgIndexes []int
+	// 原始分页参数，limit < 0 表示不分页
+	offset int64
+	limit  int64
+	// 追加到查询列末尾的衍生列数量，归并后剔除
+	derived int
+	// 改写后查询列数量，及每一列之前 * 的数量，用于定位结果列
+	selectCount int
+	starsBefore []int
+}
+
+// columnRef 查询列引用，pos 为查询列位置，位于 * 中时 pos 为 -1，按列名 label 定位
+type columnRef struct {
+	pos   int
+	label string
+}
+
+type orderByItem struct {
+	columnRef
+	desc bool
+}
+
+// aggregationItem 聚合列及其在查询列中的位置，AVG 额外记录衍生的 SUM、COUNT 列位置，
+// DISTINCT 聚合在数据节点上改写为按参数分组，归并时对参数去重后计算
+type aggregationItem struct {
+	typ      aggregationType
+	pos      int
+	sumPos   int
+	countPos int
+	distinct bool
+}
+
+// computedItem 表达式列及其在查询列中的位置，refs 为表达式中的聚合函数及列对应的衍生列
+type computedItem struct {
+	pos  int
+	expr sqlparser.Expr
+	refs map[string]columnRef
+}
+
+// newMergePlan
+//
+//	@Description: 生成归并计划，并将查询改写为各数据节点可执行的形式：
+//	排序、分组、HAVING 引用的列不在查询列中时追加衍生列，AVG 追加衍生的 SUM、COUNT 列，
+//	DISTINCT 聚合改写为按参数分组，HAVING 移至归并后执行，
+//	无分组时 LIMIT offset, n 改写为 LIMIT offset+n，有分组时去掉 LIMIT
+//	@param node
+//	@return *mergePlan
+//	@return error
+func newMergePlan(node *sqlparser.Select) (*mergePlan, error) {
+	plan := &mergePlan{limit: -1, distinct: node.Distinct != ""}
+	for _, order := range node.OrderBy {
+		plan.orderBy = append(plan.orderBy, orderByItem{columnRef: plan.reference(node, order.Expr, orderByDerivedAlias), desc: order.Direction == sqlparser.DescScr})
+	}
+	for _, expr := range node.GroupBy {
+		plan.groupBy = append(plan.groupBy, plan.reference(node, expr, groupByDerivedAlias))
+	}
+	if node.Having != nil {
+		plan.having, plan.havingRefs = node.Having.Expr, map[string]columnRef{}
+		_ = sqlparser.Walk(func(n sqlparser.SQLNode) (bool, error) {
+			switch n := n.(type) {
+			case *sqlparser.FuncExpr, *sqlparser.ColName:
+				plan.havingRefs[sqlparser.String(n)] = plan.reference(node, n.(sqlparser.Expr), havingDerivedAlias)
+				return false, nil
+			}
+			return true, nil
+		}, node.Having.Expr)
+		node.Having = nil
+	}
+	if err := plan.rewriteAggregations(node); err != nil {
+		return nil, err
+	}
+	plan.grouped = len(node.GroupBy) > 0 || len(plan.aggregations) > 0
+	if node.Limit != nil {
+		var err error
+		if node.Limit.Offset != nil {
+			if plan.offset, err = limitValue(node.Limit.Offset); err != nil {
+				return nil, err
+			}
+		}
+		if plan.limit, err = limitValue(node.Limit.Rowcount); err != nil {
+			return nil, err
+		}
+		if plan.grouped || plan.distinct {
+			// 分组、去重需要全部数据，归并后再分页
+			node.Limit = nil
+		} else {
+			node.Limit = &sqlparser.Limit{Rowcount: sqlparser.NewIntVal([]byte(strconv.FormatInt(plan.offset+plan.limit, 10)))}
+		}
+	}
+	plan.selectCount = len(node.SelectExprs)
+	plan.starsBefore = make([]int, len(node.SelectExprs))
+	for pos, stars := 0, 0; pos < len(node.SelectExprs); pos++ {
+		plan.starsBefore[pos] = stars
+		if _, ok := node.SelectExprs[pos].(*sqlparser.StarExpr); ok {
+			stars++
+		}
+	}
+	indexes, err := placeholderArgs(node)
+	if err != nil {
+		return nil, err
+	}
+	plan.shardArgIndexes = indexes[0]
+	return plan, nil
+}
+
+// rewriteAggregations 识别聚合列，AVG 追加衍生的 SUM、COUNT 列，DISTINCT 聚合改写为其参数并加入分组，
+// 聚合函数位于表达式中时按表达式列处理
+func (p *mergePlan) rewriteAggregations(node *sqlparser.Select) error {
+	for pos, n := 0, len(node.SelectExprs); pos < n; pos++ {
+		aliased, ok := node.SelectExprs[pos].(*sqlparser.AliasedExpr)
+		if !ok {
+			continue
+		}
+		fn, typ, ok := parseAggregation(aliased.Expr)
+		if !ok {
+			if containsAggregation(aliased.Expr) {
+				item := computedItem{pos: pos, expr: aliased.Expr, refs: map[string]columnRef{}}
+				if err := p.deriveComputed(node, aliased.Expr, item.refs); err != nil {
+					return err
+				}
+				p.computed = append(p.computed, item)
+			}
+			continue
+		}
+		item := aggregationItem{typ: typ, pos: pos, distinct: fn.Distinct}
+		if fn.Distinct {
+			arg, ok := distinctArgument(fn)
+			if !ok {
+				return fmt.Errorf("aggregation %s is not supported across data nodes", sqlparser.String(fn))
+			}
+			as := aliased.As
+			if as.IsEmpty() {
+				// 保持原有列名
+				as = sqlparser.NewColIdent(sqlparser.String(fn))
+			}
+			node.SelectExprs[pos] = &sqlparser.AliasedExpr{Expr: arg, As: as}
+			node.GroupBy = append(node.GroupBy, arg)
+		} else if typ == aggregationAvg {
+			item.sumPos = p.derive(node, &sqlparser.FuncExpr{Name: sqlparser.NewColIdent(string(aggregationSum)), Exprs: fn.Exprs}, avgSumDerivedAlias)
+			item.countPos = p.derive(node, &sqlparser.FuncExpr{Name: sqlparser.NewColIdent(string(aggregationCount)), Exprs: fn.Exprs}, avgCountDerivedAlias)
+		}
+		p.aggregations = append(p.aggregations, item)
+	}
+	return nil
+}
+
+// deriveComputed
+//
+//	@Description: 表达式中的聚合函数追加为衍生的聚合列，列引用追加为衍生列，
+//	仅支持四则运算、取负、括号、COALESCE/IFNULL 及常量，其余形式无法在归并后计算，返回错误
+//	@param node
+//	@param expr
+//	@param refs	表达式中的聚合函数及列对应的衍生列
+//	@return error
+func (p *mergePlan) deriveComputed(node *sqlparser.Select, expr sqlparser.Expr, refs map[string]columnRef) error {
+	switch e := expr.(type) {
+	case *sqlparser.FuncExpr:
+		if fn, typ, ok := parseAggregation(e); ok {
+			if fn.Distinct {
+				return fmt.Errorf("aggregation %s in expression is not supported across data nodes", sqlparser.String(fn))
+			}
+			key := sqlparser.String(fn)
+			if _, ok := refs[key]; ok {
+				return nil
+			}
+			item := aggregationItem{typ: typ, pos: p.derive(node, fn, aggregationDerivedAlias)}
+			if typ == aggregationAvg {
+				item.sumPos = p.derive(node, &sqlparser.FuncExpr{Name: sqlparser.NewColIdent(string(aggregationSum)), Exprs: fn.Exprs}, avgSumDerivedAlias)
+				item.countPos = p.derive(node, &sqlparser.FuncExpr{Name: sqlparser.NewColIdent(string(aggregationCount)), Exprs: fn.Exprs}, avgCountDerivedAlias)
+			}
+			p.aggregations = append(p.aggregations, item)
+			refs[key] = columnRef{pos: item.pos}
+			return nil
+		}
+		if !isCoalesce(e) {
+			return fmt.Errorf("expression %s is not supported across data nodes", sqlparser.String(e))
+		}
+		for _, arg := range e.Exprs {
+			aliased, ok := arg.(*sqlparser.AliasedExpr)
+			if !ok {
+				return fmt.Errorf("expression %s is not supported across data nodes", sqlparser.String(e))
+			}
+			if err := p.deriveComputed(node, aliased.Expr, refs); err != nil {
+				return err
+			}
+		}
+		return nil
+	case *sqlparser.BinaryExpr:
+		switch e.Operator {
+		case sqlparser.PlusStr, sqlparser.MinusStr, sqlparser.MultStr, sqlparser.DivStr:
+		default:
+			return fmt.Errorf("expression %s is not supported across data nodes", sqlparser.String(e))
+		}
+		if err := p.deriveComputed(node, e.Left, refs); err != nil {
+			return err
+		}
+		return p.deriveComputed(node, e.Right, refs)
+	case *sqlparser.UnaryExpr:
+		if e.Operator != sqlparser.UMinusStr {
+			return fmt.Errorf("expression %s is not supported across data nodes", sqlparser.String(e))
+		}
+		return p.deriveComputed(node, e.Expr, refs)
+	case *sqlparser.ParenExpr:
+		return p.deriveComputed(node, e.Expr, refs)
+	case *sqlparser.ColName:
+		refs[sqlparser.String(e)] = p.reference(node, e, aggregationDerivedAlias)
+		return nil
+	case *sqlparser.SQLVal, *sqlparser.NullVal:
+		return nil
+	}
+	return fmt.Errorf("expression %s is not supported across data nodes", sqlparser.String(expr))
+}
+
+// isCoalesce 是否为 COALESCE、IFNULL
+func isCoalesce(fn *sqlparser.FuncExpr) bool {
+	name := fn.Name.Lowered()
+	return fn.Qualifier.IsEmpty() && !fn.Distinct && (name == "coalesce" || name == "ifnull")
+}
+
+// distinctArgument DISTINCT 聚合的唯一参数
+func distinctArgument(fn *sqlparser.FuncExpr) (sqlparser.Expr, bool) {
+	if len(fn.Exprs) != 1 {
+		return nil, false
+	}
+	if arg, ok := fn.Exprs[0].(*sqlparser.AliasedExpr); ok {
+		return arg.Expr, true
+	}
+	return nil, false
+}
+
+// reference 表达式在查询列中的引用，不存在时追加衍生列
+func (p *mergePlan) reference(node *sqlparser.Select, expr sqlparser.Expr, alias string) columnRef {
+	if ref, ok := selectReference(node.SelectExprs, expr); ok {
+		return ref
+	}
+	return columnRef{pos: p.derive(node, expr, alias)}
+}
+
+// derive 追加衍生列，返回其在查询列中的位置
+func (p *mergePlan) derive(node *sqlparser.Select, expr sqlparser.Expr, alias string) int {
+	node.SelectExprs = append(node.SelectExprs, &sqlparser.AliasedExpr{Expr: expr, As: sqlparser.NewColIdent(fmt.Sprintf(alias, p.derived))})
+	p.derived++
+	return len(node.SelectExprs) - 1
+}
+
+// index 引用对应的结果列序号，* 之后的列从末尾倒数定位
+func (p *mergePlan) index(ref columnRef, columns []string) int {
+	if ref.pos < 0 {
+		return columnIndex(columns, ref.label)
+	}
+	if p.starsBefore[ref.pos] == 0 {
+		return ref.pos
+	}
+	return len(columns) - (p.selectCount - ref.pos)
+}
+
+// selectReference 表达式在查询列中的引用，按别名、列名或表达式匹配：两侧均带表名限定时须限定相同，
+// 一侧无限定时仅在同名列唯一时匹配；无限定的列名可由 * 展开时按列名定位，带限定时追加衍生列以免关联查询中同名列混淆
+func selectReference(selectExprs sqlparser.SelectExprs, expr sqlparser.Expr) (columnRef, bool) {
+	col, isCol := expr.(*sqlparser.ColName)
+	star := false
+	matched, matches := -1, 0
+	for pos, selectExpr := range selectExprs {
+		switch e := selectExpr.(type) {
+		case *sqlparser.StarExpr:
+			star = true
+		case *sqlparser.AliasedExpr:
+			if !e.As.IsEmpty() && isCol && col.Qualifier.IsEmpty() && col.Name.Equal(e.As) {
+				return columnRef{pos: pos}, true
+			}
+			if sqlparser.String(e.Expr) == sqlparser.String(expr) {
+				return columnRef{pos: pos}, true
+			}
+			if c, ok := e.Expr.(*sqlparser.ColName); ok && isCol && c.Name.Equal(col.Name) {
+				if !c.Qualifier.IsEmpty() && !col.Qualifier.IsEmpty() {
+					// 限定不同的同名列，如 o.id 与 i.id
+					continue
+				}
+				if matches++; matched < 0 {
+					matched = pos
+				}
+			}
+		}
+	}
+	if matches == 1 {
+		return columnRef{pos: matched}, true
+	}
+	if star && isCol && col.Qualifier.IsEmpty() && matches == 0 {
+		return columnRef{pos: -1, label: col.Name.String()}, true
+	}
+	return columnRef{}, false
+}
+
+// shardArgs 下发到数据节点的参数，按改写后语句中占位符的顺序重新排列
+func (p *mergePlan) shardArgs(args []interface{}) []interface{} {
+	shardArgs := make([]interface{}, 0, len(p.shardArgIndexes))
+	for _, index := range p.shardArgIndexes {
+		if index < 0 || index >= len(args) {
+			return args
+		}
+		shardArgs = append(shardArgs, args[index])
+	}
+	return shardArgs
+}
+
+// merge 按计划归并各数据节点的结果：分组聚合、去重或多路归并排序，再应用原始分页
+func (p *mergePlan) merge(sets []*resultSet, args []interface{}) (*resultSet, error) {
+	rs := &resultSet{}
+	var rows [][]driver.Value
+	for _, set := range sets {
+		if set == nil {
+			continue
+		}
+		if rs.columns == nil {
+			rs.columns, rs.scanTypes, rs.dbTypes = set.columns, set.scanTypes, set.dbTypes
+		}
+		rows = append(rows, set.rows...)
+	}
+	if !p.grouped && !p.distinct {
+		return p.mergeSorted(rs, sets)
+	}
+
+	var err error
+	if p.grouped {
+		if rows, err = p.group(rows, rs.columns, args); err != nil {
+			return nil, err
+		}
+	} else {
+		rows = p.dedupe(rows, len(rs.columns)-p.derived)
+	}
+	if err = p.sort(rows, rs.columns); err != nil {
+		return nil, err
+	}
+	if p.offset > 0 {
+		if p.offset >= int64(len(rows)) {
+			rows = nil
+		} else {
+			rows = rows[p.offset:]
+		}
+	}
+	if p.limit >= 0 && p.limit < int64(len(rows)) {
+		rows = rows[:p.limit]
+	}
+	rs.rows = rows
+	p.trimDerived(rs)
+	return rs, nil
+}
+
+// mergeSorted 各数据节点结果已按排序项有序，多路归并后应用原始分页
+func (p *mergePlan) mergeSorted(rs *resultSet, sets []*resultSet) (*resultSet, error) {
+	indexes, err := p.orderByIndexes(rs.columns)
+	if err != nil {
+		return nil, err
+	}
+	queue := &mergeQueue{indexes: indexes, items: p.orderBy}
+	for i, set := range sets {
+		if set != nil && len(set.rows) > 0 {
+			queue.cursors = append(queue.cursors, &mergeCursor{rows: set.rows, node: i})
+		}
+	}
+	heap.Init(queue)
+	var skipped int64
+	for queue.Len() > 0 {
+		if p.limit >= 0 && int64(len(rs.rows)) >= p.limit {
+			break
+		}
+		cursor := queue.cursors[0]
+		row := cursor.rows[cursor.pos]
+		if cursor.pos++; cursor.pos < len(cursor.rows) {
+			heap.Fix(queue, 0)
+		} else {
+			heap.Pop(queue)
+		}
+		if skipped < p.offset {
+			skipped++
+			continue
+		}
+		rs.rows = append(rs.rows, row)
+	}
+	p.trimDerived(rs)
+	return rs, nil
+}
+
+// group 按分组列重新分组并合并聚合列，再执行 HAVING，无分组时全部数据为一组
+func (p *mergePlan) group(rows [][]driver.Value, columns []string, args []interface{}) ([][]driver.Value, error) {
+	indexes := make([]int, len(p.groupBy))
+	for i, ref := range p.groupBy {
+		if indexes[i] = p.index(ref, columns); indexes[i] < 0 {
+			return nil, fmt.Errorf("group by column %s not found in result columns", ref.label)
+		}
+	}
+	var keys []string
+	groups := make(map[string][][]driver.Value)
+	for _, row := range rows {
+		key := rowKey(row, indexes)
+		if _, ok := groups[key]; !ok {
+			keys = append(keys, key)
+		}
+		groups[key] = append(groups[key], row)
+	}
+	var result [][]driver.Value
+	if len(keys) == 0 && len(p.groupBy) == 0 && len(columns) > 0 {
+		// 无分组的聚合查询始终返回一行
+		row, err := p.emptyAggregation(columns, args)
+		if err != nil {
+			return nil, err
+		}
+		result = append(result, row)
+	}
+	for _, key := range keys {
+		row, err := p.aggregate(groups[key], columns, args)
+		if err != nil {
+			return nil, err
+		}
+		result = append(result, row)
+	}
+	if p.having == nil {
+		return result, nil
+	}
+	filtered := result[:0]
+	for _, row := range result {
+		value, err := p.evalExpr(p.having, p.havingRefs, row, columns, args)
+		if err != nil {
+			return nil, err
+		}
+		if truthy(value) {
+			filtered = append(filtered, row)
+		}
+	}
+	return filtered, nil
+}
+
+// dedupe 按全部非衍生列去重，保留首次出现的行
+func (p *mergePlan) dedupe(rows [][]driver.Value, n int) [][]driver.Value {
+	indexes := make([]int, n)
+	for i := range indexes {
+		indexes[i] = i
+	}
+	seen := make(map[string]bool)
+	result := rows[:0]
+	for _, row := range rows {
+		if key := rowKey(row, indexes); !seen[key] {
+			seen[key] = true
+			result = append(result, row)
+		}
+	}
+	return result
+}
+
+// sort 按排序项排序，无排序项时按分组列排序
+func (p *mergePlan) sort(rows [][]driver.Value, columns []string) error {
+	items := p.orderBy
+	if len(items) == 0 {
+		for _, ref := range p.groupBy {
+			items = append(items, orderByItem{columnRef: ref})
+		}
+	}
+	if len(items) == 0 {
+		return nil
+	}
+	indexes := make([]int, len(items))
+	for i, item := range items {
+		if indexes[i] = p.index(item.columnRef, columns); indexes[i] < 0 {
+			return fmt.Errorf("order by column %s not found in result columns", item.label)
+		}
+	}
+	sort.SliceStable(rows, func(i, j int) bool {
+		for k, index := range indexes {
+			if c := compareValues(rows[i][index], rows[j][index]); c != 0 {
+				return (c < 0) != items[k].desc
+			}
+		}
+		return false
+	})
+	return nil
+}
+
+// aggregate 合并同组多行的聚合列：COUNT、SUM 求和，MIN、MAX 取最值，AVG 由衍生的 SUM/COUNT 计算，
+// DISTINCT 聚合对参数去重后计算，表达式列按合并后的聚合值计算，其余列取首行
+func (p *mergePlan) aggregate(rows [][]driver.Value, columns []string, args []interface{}) ([]driver.Value, error) {
+	result := make([]driver.Value, len(rows[0]))
+	copy(result, rows[0])
+	for _, item := range p.aggregations {
+		index := p.index(columnRef{pos: item.pos}, columns)
+		switch {
+		case item.typ == aggregationMin || item.typ == aggregationMax:
+			var value driver.Value
+			for _, row := range rows {
+				if row[index] == nil {
+					continue
+				}
+				c := compareValues(row[index], value)
+				if value == nil || (item.typ == aggregationMin && c < 0) || (item.typ == aggregationMax && c > 0) {
+					value = row[index]
+				}
+			}
+			result[index] = value
+		case item.distinct:
+			var sum, count sumValue
+			seen := make(map[string]bool)
+			for _, row := range rows {
+				key := rowKey(row, []int{index})
+				if row[index] == nil || seen[key] {
+					continue
+				}
+				seen[key] = true
+				sum.add(row[index])
+				count.add(int64(1))
+			}
+			switch item.typ {
+			case aggregationCount:
+				result[index] = int64(len(seen))
+			case aggregationSum:
+				result[index] = sum.value()
+			case aggregationAvg:
+				result[index] = avgValue(sum, count)
+			}
+		case item.typ == aggregationCount || item.typ == aggregationSum:
+			var sum sumValue
+			for _, row := range rows {
+				sum.add(row[index])
+			}
+			result[index] = sum.value()
+		case item.typ == aggregationAvg:
+			var sum, count sumValue
+			sumIndex, countIndex := p.index(columnRef{pos: item.sumPos}, columns), p.index(columnRef{pos: item.countPos}, columns)
+			for _, row := range rows {
+				sum.add(row[sumIndex])
+				count.add(row[countIndex])
+			}
+			result[index] = avgValue(sum, count)
+		}
+	}
+	return result, p.compute(result, columns, args)
+}
+
+// emptyAggregation 无数据时的聚合结果，COUNT 为 0，其余为 NULL，表达式列按此计算
+func (p *mergePlan) emptyAggregation(columns []string, args []interface{}) ([]driver.Value, error) {
+	result := make([]driver.Value, len(columns))
+	for _, item := range p.aggregations {
+		if item.typ == aggregationCount {
+			result[p.index(columnRef{pos: item.pos}, columns)] = int64(0)
+		}
+	}
+	return result, p.compute(result, columns, args)
+}
+
+// compute 按合并后的聚合值计算表达式列
+func (p *mergePlan) compute(row []driver.Value, columns []string, args []interface{}) error {
+	for _, item := range p.computed {
+		value, err := p.evalExpr(item.expr, item.refs, row, columns, args)
+		if err != nil {
+			return err
+		}
+		row[p.index(columnRef{pos: item.pos}, columns)] = value
+	}
+	return nil
+}
+
+func (p *mergePlan) orderByIndexes(columns []string) ([]int, error) {
+	indexes := make([]int, len(p.orderBy))
+	for i, item := range p.orderBy {
+		if indexes[i] = p.index(item.columnRef, columns); indexes[i] < 0 {
+			return nil, fmt.Errorf("order by column %s not found in result columns", item.label)
+		}
+	}
+	return indexes, nil
+}
+
+// rowKey 指定列的取值拼接为分组、去重的键
+func rowKey(row []driver.Value, indexes []int) string {
+	var key strings.Builder
+	for _, index := range indexes {
+		if row[index] == nil {
+			key.WriteString("N|")
+			continue
+		}
+		key.WriteString(strconv.Quote(toString(row[index])))
+		key.WriteByte('|')
+	}
+	return key.String()
+}
+
+func limitValue(expr sqlparser.Expr) (int64, error) {
+	if val, ok := expr.(*sqlparser.SQLVal); ok && val.Type == sqlparser.IntVal {
+		return strconv.ParseInt(string(val.Val), 10, 64)
+	}
+	return 0, fmt.Errorf("limit/offset must be an integer constant across data nodes: %s", sqlparser.String(expr))
+}
+
+// trimDerived 剔除衍生列
+func (p *mergePlan) trimDerived(rs *resultSet) {
+	if p.derived == 0 || len(rs.columns) < p.derived {
+		return
+	}
+	n := len(rs.columns) - p.derived
+	rs.columns = rs.columns[:n]
+	if len(rs.scanTypes) > n {
+		rs.scanTypes = rs.scanTypes[:n]
+	}
+	if len(rs.dbTypes) > n {
+		rs.dbTypes = rs.dbTypes[:n]
+	}
+	for i, row := range rs.rows {
+		rs.rows[i] = row[:n]
+	}
+}
+
+func columnIndex(columns []string, label string) int {
+	for i, column := range columns {
+		if strings.EqualFold(column, label) {
+			return i
+		}
+	}
+	return -1
+}
+
+type mergeCursor struct {
+	rows [][]driver.Value
+	pos  int
+	// 数据节点序号，排序值相同时按路由顺序输出
+	node int
+}
+
+// mergeQueue 以各数据节点当前行为元素的小顶堆
+type mergeQueue struct {
+	cursors []*mergeCursor
+	indexes []int
+	items   []orderByItem
+}
+
+func (q *mergeQueue) Len() int {
+	return len(q.cursors)
+}
+
+func (q *mergeQueue) Less(i, j int) bool {
+	a, b := q.cursors[i].rows[q.cursors[i].pos], q.cursors[j].rows[q.cursors[j].pos]
+	for k, index := range q.indexes {
+		if c := compareValues(a[index], b[index]); c != 0 {
+			if q.items[k].desc {
+				return c > 0
+			}
+			return c < 0
+		}
+	}
+	return q.cursors[i].node < q.cursors[j].node
+}
+
+func (q *mergeQueue) Swap(i, j int) {
+	q.cursors[i], q.cursors[j] = q.cursors[j], q.cursors[i]
+}
+
+func (q *mergeQueue) Push(x interface{}) {
+	q.cursors = append(q.cursors, x.(*mergeCursor))
+}
+
+func (q *mergeQueue) Pop() interface{} {
+	n := len(q.cursors)
+	cursor := q.cursors[n-1]
+	q.cursors = q.cursors[:n-1]
+	return cursor
+}
+
+// compareValues 比较两个数据库返回值，nil 最小，数值按数值比较，其余按字符串比较
+func compareValues(a, b driver.Value) int {
+	if a == nil || b == nil {
+		switch {
+		case a == nil && b == nil:
+			return 0
+		case a == nil:
+			return -1
+		default:
+			return 1
+		}
+	}
+	if x, ok := a.(int64); ok {
+		if y, ok := b.(int64); ok {
+			return compareInt(x, y)
+		}
+	}
+	if x, ok := a.(time.Time); ok {
+		if y, ok := b.(time.Time); ok {
+			return x.Compare(y)
+		}
+	}
+	if x, ok := toFloat(a); ok {
+		if y, ok := toFloat(b); ok {
+			switch {
+			case x < y:
+				return -1
+			case x > y:
+				return 1
+			}
+			return 0
+		}
+	}
+	return bytes.Compare([]byte(toString(a)), []byte(toString(b)))
+}
+
+func compareInt(x, y int64) int {
+	switch {
+	case x < y:
+		return -1
+	case x > y:
+		return 1
+	}
+	return 0
+}
+
+func toFloat(v driver.Value) (float64, bool) {
+	switch n := v.(type) {
+	case int64:
+		return float64(n), true
+	case float64:
+		return n, true
+	case []byte:
+		f, err := strconv.ParseFloat(string(n), 64)
+		return f, err == nil
+	case string:
+		f, err := strconv.ParseFloat(n, 64)
+		return f, err == nil
+	}
+	return 0, false
+}
+
+func toString(v driver.Value) string {
+	switch s := v.(type) {
+	case []byte:
+		return string(s)
+	case string:
+		return s
+	case time.Time:
+		return s.Format(time.RFC3339Nano)
+	}
+	return fmt.Sprintf("%v", v)
+}
+
+// sumValue 数值累加，整数保持整数，DECIMAL 等定点小数按精确小数累加，出现浮点数后按浮点数计算，忽略 nil
+type sumValue struct {
+	i       int64
+	f       float64
+	isFloat bool
+	// d 定点小数之和，scale 为其中最大的小数位数
+	d     *big.Rat
+	scale int
+	valid bool
+}
+
+func (s *sumValue) add(v driver.Value) {
+	switch n := v.(type) {
+	case nil:
+		return
+	case int64:
+		s.i += n
+	case float64:
+		s.f += n
+		s.isFloat = true
+	default:
+		str := toString(v)
+		if i, err := strconv.ParseInt(str, 10, 64); err == nil {
+			s.i += i
+		} else if d, scale, ok := parseDecimal(str); ok {
+			if s.d == nil {
+				s.d = new(big.Rat)
+			}
+			s.d.Add(s.d, d)
+			if scale > s.scale {
+				s.scale = scale
+			}
+		} else if f, err := strconv.ParseFloat(str, 64); err == nil {
+			s.f += f
+			s.isFloat = true
+		} else {
+			return
+		}
+	}
+	s.valid = true
+}
+
+func (s sumValue) value() driver.Value {
+	if !s.valid {
+		return nil
+	}
+	if s.isFloat {
+		return s.float()
+	}
+	if s.d != nil {
+		return new(big.Rat).Add(s.d, new(big.Rat).SetInt64(s.i)).FloatString(s.scale)
+	}
+	return s.i
+}
+
+func (s sumValue) float() float64 {
+	f := s.f + float64(s.i)
+	if s.d != nil {
+		d, _ := s.d.Float64()
+		f += d
+	}
+	return f
+}
+
+// avgValue 平均值，定点小数的结果比原小数位数多 4 位（与 MySQL div_precision_increment 默认值一致）
+func avgValue(sum, count sumValue) driver.Value {
+	if !sum.valid || count.float() == 0 {
+		return nil
+	}
+	if sum.d != nil && !sum.isFloat && !count.isFloat && count.d == nil {
+		total := new(big.Rat).Add(sum.d, new(big.Rat).SetInt64(sum.i))
+		return total.Quo(total, new(big.Rat).SetInt64(count.i)).FloatString(sum.scale + 4)
+	}
+	return sum.float() / count.float()
+}
+
+// parseDecimal 解析定点小数，如 DECIMAL 列返回的 12.50，返回其值及小数位数，科学计数法等不视为定点小数
+func parseDecimal(s string) (*big.Rat, int, bool) {
+	digits := strings.TrimLeft(s, "+-")
+	point := strings.IndexByte(digits, '.')
+	if digits == "" || point < 0 || strings.Trim(digits, "0123456789.") != "" || strings.Count(digits, ".") != 1 {
+		return nil, 0, false
+	}
+	d, ok := new(big.Rat).SetString(s)
+	if !ok {
+		return nil, 0, false
+	}
+	return d, len(digits) - point - 1, true
+}
@@ -0,0 +1,228 @@
+package dbroute
+
+import (
+	"database/sql/driver"
+	"reflect"
+	"testing"
+
+	"github.com/xwb1989/sqlparser"
+)
+
+func TestMergePlan(t *testing.T) {
+	tests := []struct {
+		name    string
+		sql     string
+		wantSql string
+		columns []string
+		shards  [][][]driver.Value
+		args    []interface{}
+		want    [][]driver.Value
+	}{
+		{
+			name:    "order by desc with offset",
+			sql:     "SELECT id, created_at FROM `order` ORDER BY created_at DESC LIMIT 1, 3",
+			wantSql: "select id, created_at from `order` order by created_at desc limit 4",
+			columns: []string{"id", "created_at"},
+			shards: [][][]driver.Value{
+				{{int64(1), int64(90)}, {int64(3), int64(70)}, {int64(5), int64(50)}},
+				{{int64(2), int64(80)}, {int64(4), int64(60)}},
+			},
+			want: [][]driver.Value{{int64(2), int64(80)}, {int64(3), int64(70)}, {int64(4), int64(60)}},
+		},
+		{
+			name:    "order by column not selected",
+			sql:     "SELECT id FROM `order` ORDER BY created_at LIMIT 2",
+			wantSql: "select id, created_at as ORDER_BY_DERIVED_0 from `order` order by created_at asc limit 2",
+			columns: []string{"id", "ORDER_BY_DERIVED_0"},
+			shards: [][][]driver.Value{
+				{{int64(1), int64(10)}, {int64(3), int64(30)}},
+				{{int64(2), int64(20)}},
+			},
+			want: [][]driver.Value{{int64(1)}, {int64(2)}},
+		},
+		{
+			name:    "join order by qualified column",
+			sql:     "SELECT o.id, i.id FROM orders o JOIN items i ON o.id = i.order_id ORDER BY i.id DESC LIMIT 3",
+			wantSql: "select o.id, i.id from orders as o join items as i on o.id = i.order_id order by i.id desc limit 3",
+			columns: []string{"id", "id"},
+			shards: [][][]driver.Value{
+				{{int64(1), int64(100)}, {int64(3), int64(90)}},
+				{{int64(2), int64(95)}, {int64(4), int64(10)}},
+			},
+			want: [][]driver.Value{{int64(1), int64(100)}, {int64(2), int64(95)}, {int64(3), int64(90)}},
+		},
+		{
+			name:    "join order by qualified column under star",
+			sql:     "SELECT o.* FROM orders o JOIN items i ON o.id = i.order_id ORDER BY i.id",
+			wantSql: "select o.*, i.id as ORDER_BY_DERIVED_0 from orders as o join items as i on o.id = i.order_id order by i.id asc",
+			columns: []string{"id", "ORDER_BY_DERIVED_0"},
+			shards: [][][]driver.Value{
+				{{int64(1), int64(20)}},
+				{{int64(2), int64(10)}},
+			},
+			want: [][]driver.Value{{int64(2)}, {int64(1)}},
+		},
+		{
+			name:    "unqualified order by matches qualified column",
+			sql:     "SELECT o.id, o.amount FROM orders o ORDER BY amount",
+			wantSql: "select o.id, o.amount from orders as o order by amount asc",
+			columns: []string{"id", "amount"},
+			shards: [][][]driver.Value{
+				{{int64(1), int64(20)}},
+				{{int64(2), int64(10)}},
+			},
+			want: [][]driver.Value{{int64(2), int64(10)}, {int64(1), int64(20)}},
+		},
+		{
+			name:    "offset beyond rows",
+			sql:     "SELECT id FROM `order` ORDER BY id LIMIT 10, 5",
+			wantSql: "select id from `order` order by id asc limit 15",
+			columns: []string{"id"},
+			shards:  [][][]driver.Value{{{int64(1)}}, {{int64(2)}}},
+			want:    nil,
+		},
+		{
+			name:    "group by with having",
+			sql:     "SELECT user_id, COUNT(*) AS cnt, SUM(amount) AS total FROM `order` GROUP BY user_id HAVING COUNT(*) > 1 ORDER BY total DESC",
+			wantSql: "select user_id, COUNT(*) as cnt, SUM(amount) as total from `order` group by user_id order by total desc",
+			columns: []string{"user_id", "cnt", "total"},
+			shards: [][][]driver.Value{
+				{{int64(1), int64(1), int64(10)}, {int64(2), int64(2), int64(5)}},
+				{{int64(1), int64(2), int64(30)}, {int64(3), int64(1), int64(100)}},
+			},
+			want: [][]driver.Value{{int64(1), int64(3), int64(40)}, {int64(2), int64(2), int64(5)}},
+		},
+		{
+			name:    "having with placeholder",
+			sql:     "SELECT user_id, SUM(amount) FROM `order` GROUP BY user_id HAVING SUM(amount) >= ?",
+			wantSql: "select user_id, SUM(amount) from `order` group by user_id",
+			columns: []string{"user_id", "SUM(amount)"},
+			shards: [][][]driver.Value{
+				{{int64(1), int64(10)}, {int64(2), int64(5)}},
+				{{int64(1), int64(30)}},
+			},
+			args: []interface{}{int64(20)},
+			want: [][]driver.Value{{int64(1), int64(40)}},
+		},
+		{
+			name:    "avg rewritten to sum and count",
+			sql:     "SELECT AVG(amount) FROM `order`",
+			wantSql: "select AVG(amount), sum(amount) as AVG_DERIVED_SUM_0, count(amount) as AVG_DERIVED_COUNT_1 from `order`",
+			columns: []string{"AVG(amount)", "AVG_DERIVED_SUM_0", "AVG_DERIVED_COUNT_1"},
+			shards: [][][]driver.Value{
+				{{float64(10), int64(20), int64(2)}},
+				{{float64(40), int64(40), int64(1)}},
+			},
+			want: [][]driver.Value{{float64(20)}},
+		},
+		{
+			name:    "min and max",
+			sql:     "SELECT MIN(amount), MAX(amount) FROM `order`",
+			wantSql: "select MIN(amount), MAX(amount) from `order`",
+			columns: []string{"MIN(amount)", "MAX(amount)"},
+			shards: [][][]driver.Value{
+				{{int64(3), int64(9)}},
+				{{int64(1), int64(7)}},
+			},
+			want: [][]driver.Value{{int64(1), int64(9)}},
+		},
+		{
+			name:    "distinct with limit",
+			sql:     "SELECT DISTINCT user_id FROM `order` ORDER BY user_id LIMIT 1, 2",
+			wantSql: "select distinct user_id from `order` order by user_id asc",
+			columns: []string{"user_id"},
+			shards: [][][]driver.Value{
+				{{int64(1)}, {int64(2)}, {int64(4)}},
+				{{int64(2)}, {int64(3)}},
+			},
+			want: [][]driver.Value{{int64(2)}, {int64(3)}},
+		},
+		{
+			name:    "count distinct",
+			sql:     "SELECT COUNT(DISTINCT user_id) FROM `order`",
+			wantSql: "select user_id as `COUNT(distinct user_id)` from `order` group by user_id",
+			columns: []string{"COUNT(distinct user_id)"},
+			shards: [][][]driver.Value{
+				{{int64(1)}, {int64(2)}},
+				{{int64(2)}, {int64(3)}},
+			},
+			want: [][]driver.Value{{int64(3)}},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			node, err := sqlparser.Parse(tt.sql)
+			if err != nil {
+				t.Fatal(err)
+			}
+			plan, err := newMergePlan(node.(*sqlparser.Select))
+			if err != nil {
+				t.Fatal(err)
+			}
+			if got := restorePlaceholder(sqlparser.String(node)); got != tt.wantSql {
+				t.Errorf("sql = %s, want %s", got, tt.wantSql)
+			}
+			sets := make([]*resultSet, len(tt.shards))
+			for i, rows := range tt.shards {
+				sets[i] = &resultSet{columns: tt.columns, rows: rows}
+			}
+			rs, err := plan.merge(sets, tt.args)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if !reflect.DeepEqual(rs.rows, tt.want) {
+				t.Errorf("rows = %v, want %v", rs.rows, tt.want)
+			}
+		})
+	}
+}
+
+func TestMergePlanShardArgs(t *testing.T) {
+	tests := []struct {
+		name     string
+		sql      string
+		args     []interface{}
+		wantSql  string
+		wantArgs []interface{}
+	}{
+		{
+			name:     "having args dropped",
+			sql:      "SELECT user_id, SUM(amount) FROM `order` WHERE status = ? GROUP BY user_id HAVING SUM(amount) > ?",
+			args:     []interface{}{"paid", 10},
+			wantSql:  "select user_id, SUM(amount) from `order` where `status` = ? group by user_id",
+			wantArgs: []interface{}{"paid"},
+		},
+		{
+			name:     "having aggregate with placeholder derived before where",
+			sql:      "SELECT user_id FROM `order` WHERE status = ? GROUP BY user_id HAVING SUM(amount * ?) > 10",
+			args:     []interface{}{"paid", 2},
+			wantSql:  "select user_id, SUM(amount * ?) as HAVING_DERIVED_0 from `order` where `status` = ? group by user_id",
+			wantArgs: []interface{}{2, "paid"},
+		},
+		{
+			name:     "order by expression with placeholder",
+			sql:      "SELECT id FROM `order` WHERE user_id = ? ORDER BY amount * ? DESC LIMIT 5, 10",
+			args:     []interface{}{1, 3},
+			wantSql:  "select id, amount * ? as ORDER_BY_DERIVED_0 from `order` where user_id = ? order by amount * ? desc limit 15",
+			wantArgs: []interface{}{3, 1, 3},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			node, err := sqlparser.Parse(tt.sql)
+			if err != nil {
+				t.Fatal(err)
+			}
+			plan, err := newMergePlan(node.(*sqlparser.Select))
+			if err != nil {
+				t.Fatal(err)
+			}
+			if got := restorePlaceholder(sqlparser.String(node)); got != tt.wantSql {
+				t.Errorf("sql = %s, want %s", got, tt.wantSql)
+			}
+			if got := plan.shardArgs(tt.args); !reflect.DeepEqual(got, tt.wantArgs) {
+				t.Errorf("args = %v, want %v", got, tt.wantArgs)
+			}
+		})
+	}
+}
@@ -0,0 +1,194 @@
+package dbroute
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"gorm.io/gorm"
+	"sort"
+	"strings"
+)
+
+type routeContextKey string
+
+// skipRouteKey 上下文中带有该键的语句不做路由，直接在语句指定的连接池执行
+const skipRouteKey routeContextKey = "gorm:db_route:skip"
+
+// TbActualTables 可列出全部物理表的分表策略，用于在每个数据节点执行迁移
+type TbActualTables interface {
+	ActualTables(tableName string) []string
+}
+
+// MigrateResult 单个数据节点的迁移结果
+type MigrateResult struct {
+	// Table 逻辑表
+	Table string
+	// Name 数据源
+	Name ShardingName
+	// ActualTable 物理表
+	ActualTable string
+	Error       error
+}
+
+// AutoMigrate
+//
+//	@Description: 在全部数据节点（主库数据源 × 物理表）执行 gorm 迁移，单个节点失败不影响其余节点，
+//	同一数据源配置多个连接池时只在第一个连接池执行；分表策略未实现 TbActualTables 或未配置物理表时迁移逻辑表；
+//	MySQL 以外的方言中显式命名的索引在多个物理表上重名时，该数据源不执行迁移并返回错误
+//	@param models
+//	@return []MigrateResult	各数据节点的迁移结果
+//	@return error	全部失败节点的错误
+func (dr *DBRoute) AutoMigrate(models ...interface{}) ([]MigrateResult, error) {
+	var (
+		results []MigrateResult
+		errs    []error
+	)
+	ctx := context.WithValue(dr.DB.Statement.Context, skipRouteKey, true)
+	for _, model := range models {
+		stmt := &gorm.Statement{DB: dr.DB}
+		if err := stmt.Parse(model); err != nil {
+			errs = append(errs, fmt.Errorf("parse model %T: %w", model, err))
+			continue
+		}
+		table := stmt.Table
+		masters := map[ShardingName][]gorm.ConnPool{Default: {dr.DB.Config.ConnPool}}
+		tables := []string{table}
+		if r := dr.lookupRoute(stmt); r != nil {
+			masters, tables = r.masters, r.actualTables(table)
+		}
+		for _, name := range shardingNames(masters) {
+			if len(masters[name]) == 0 {
+				continue
+			}
+			tx := dr.session(ctx, masters[name][0])
+			if err := namedIndexConflict(tx, model, tables); err != nil {
+				errs = append(errs, fmt.Errorf("migrate %s on %s: %w", table, name, err))
+				for _, actualTable := range tables {
+					results = append(results, MigrateResult{Table: table, Name: name, ActualTable: actualTable, Error: err})
+				}
+				continue
+			}
+			for _, actualTable := range tables {
+				result := MigrateResult{Table: table, Name: name, ActualTable: actualTable}
+				if result.Error = tx.Table(actualTable).AutoMigrate(model); result.Error != nil {
+					errs = append(errs, fmt.Errorf("migrate %s on %s: %w", actualTable, name, result.Error))
+					dr.Logger.Error(ctx, "migrate %s on %s: %v", actualTable, name, result.Error)
+				} else {
+					dr.Logger.Info(ctx, "migrate %s on %s", actualTable, name)
+				}
+				results = append(results, result)
+			}
+		}
+	}
+	return results, errors.Join(errs...)
+}
+
+// namedIndexConflict
+//
+//	@Description: 除 MySQL 外（如 PostgreSQL、SQLite）索引名在同一 schema 内唯一，gorm 默认的索引名含物理表名互不冲突，
+//	显式命名的索引（如 index:idx_status）在同一数据源的多个物理表上重名，迁移前返回错误，需改用默认索引名
+//	@param tx	数据源的会话
+//	@param model
+//	@param tables	数据源上的全部物理表
+//	@return error
+func namedIndexConflict(tx *gorm.DB, model interface{}, tables []string) error {
+	if len(tables) < 2 || tx.Dialector.Name() == "mysql" {
+		return nil
+	}
+	names := make([]map[string]bool, 2)
+	for i, table := range tables[:2] {
+		stmt := &gorm.Statement{DB: tx}
+		if err := stmt.ParseWithSpecialTableName(model, table); err != nil {
+			return err
+		}
+		names[i] = make(map[string]bool)
+		for name := range stmt.Schema.ParseIndexes() {
+			names[i][name] = true
+		}
+	}
+	var conflicts []string
+	for name := range names[0] {
+		if names[1][name] {
+			conflicts = append(conflicts, name)
+		}
+	}
+	if len(conflicts) > 0 {
+		sort.Strings(conflicts)
+		return fmt.Errorf("index names %v are shared by actual tables %s and %s, %s requires index names unique per schema", conflicts, tables[0], tables[1], tx.Dialector.Name())
+	}
+	return nil
+}
+
+// actualTables 逻辑表的全部物理表，分表策略无法列出时为逻辑表本身
+func (r *route) actualTables(table string) []string {
+	if policy, ok := r.tbPolicy.(TbActualTables); ok {
+		if actualTables := policy.ActualTables(table); len(actualTables) > 0 {
+			return actualTables
+		}
+	}
+	return []string{table}
+}
+
+// shardingNames 按名称自然排序的数据源，名称中的数字按数值比较，如 ds_2 排在 ds_10 之前
+func shardingNames(connPoolsMap map[ShardingName][]gorm.ConnPool) []ShardingName {
+	names := make([]ShardingName, 0, len(connPoolsMap))
+	for name := range connPoolsMap {
+		names = append(names, name)
+	}
+	sortShardingNames(names)
+	return names
+}
+
+// sortShardingNames 按名称自然排序
+func sortShardingNames(names []ShardingName) {
+	sort.Slice(names, func(i, j int) bool { return naturalLess(string(names[i]), string(names[j])) })
+}
+
+// naturalLess 自然排序比较，连续的数字按数值比较，数值相同时按原字符串比较
+func naturalLess(a, b string) bool {
+	i, j := 0, 0
+	for i < len(a) && j < len(b) {
+		if isDigit(a[i]) && isDigit(b[j]) {
+			si, sj := i, j
+			for i < len(a) && isDigit(a[i]) {
+				i++
+			}
+			for j < len(b) && isDigit(b[j]) {
+				j++
+			}
+			x, y := strings.TrimLeft(a[si:i], "0"), strings.TrimLeft(b[sj:j], "0")
+			if len(x) != len(y) {
+				return len(x) < len(y)
+			}
+			if x != y {
+				return x < y
+			}
+			continue
+		}
+		if a[i] != b[j] {
+			return a[i] < b[j]
+		}
+		i++
+		j++
+	}
+	if len(a)-i != len(b)-j {
+		return len(a)-i < len(b)-j
+	}
+	return a < b
+}
+
+func isDigit(c byte) bool {
+	return '0' <= c && c <= '9'
+}
+
+// session 在指定连接池上执行且不做路由的会话，使用连接池对应的方言
+func (dr *DBRoute) session(ctx context.Context, connPool gorm.ConnPool) *gorm.DB {
+	tx := dr.DB.Session(&gorm.Session{NewDB: true, Context: ctx})
+	if dialector, ok := dr.dialectors[connPool]; ok {
+		config := *tx.Config
+		config.Dialector = dialector
+		tx.Config = &config
+	}
+	tx.Statement.ConnPool = connPool
+	return tx
+}
@@ -0,0 +1,72 @@
+package dbroute
+
+import (
+	"testing"
+
+	"gorm.io/gorm"
+	"gorm.io/gorm/logger"
+)
+
+type testDefaultIndexOrder struct {
+	ID     int64
+	Status string `gorm:"index"`
+}
+
+type testNamedIndexOrder struct {
+	ID     int64
+	Status string `gorm:"index:idx_status"`
+	UserID int64  `gorm:"uniqueIndex:uk_user"`
+}
+
+func TestNamedIndexConflict(t *testing.T) {
+	tests := []struct {
+		name      string
+		dialector gorm.Dialector
+		model     interface{}
+		tables    []string
+		wantErr   string
+	}{
+		{name: "default index names", dialector: testDialector{}, model: &testDefaultIndexOrder{}, tables: []string{"order_0", "order_1"}},
+		{name: "named index", dialector: testDialector{}, model: &testNamedIndexOrder{}, tables: []string{"order_0", "order_1"}, wantErr: "index names [idx_status uk_user] are shared by actual tables order_0 and order_1, test requires index names unique per schema"},
+		{name: "single actual table", dialector: testDialector{}, model: &testNamedIndexOrder{}, tables: []string{"order"}},
+		{name: "mysql index names unique per table", dialector: testMysqlDialector{}, model: &testNamedIndexOrder{}, tables: []string{"order_0", "order_1"}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			tx, err := gorm.Open(tt.dialector, &gorm.Config{Logger: logger.Discard})
+			if err != nil {
+				t.Fatal(err)
+			}
+			err = namedIndexConflict(tx, tt.model, tt.tables)
+			if (err == nil) != (tt.wantErr == "") || (err != nil && err.Error() != tt.wantErr) {
+				t.Errorf("namedIndexConflict() error = %v, want %q", err, tt.wantErr)
+			}
+		})
+	}
+}
+
+func TestNaturalLess(t *testing.T) {
+	tests := []struct {
+		a, b string
+		want bool
+	}{
+		{a: "ds_2", b: "ds_10", want: true},
+		{a: "ds_10", b: "ds_2", want: false},
+		{a: "ds_1", b: "ds_1", want: false},
+		{a: "ds_01", b: "ds_1", want: true},
+		{a: "ds_1", b: "ds_01", want: false},
+		{a: "ds_9", b: "dt_1", want: true},
+		{a: "ds", b: "ds_0", want: true},
+		{a: "ds_0", b: "ds", want: false},
+		{a: "ds_1_b", b: "ds_1_c", want: true},
+		{a: "order_2_10", b: "order_2_9", want: false},
+		{a: "a100000000000000000000", b: "a99999999999999999999", want: false},
+	}
+	for _, tt := range tests {
+		t.Run(tt.a+" "+tt.b, func(t *testing.T) {
+			if got := naturalLess(tt.a, tt.b); got != tt.want {
+				t.Errorf("naturalLess(%s, %s) = %v, want %v", tt.a, tt.b, got, tt.want)
+			}
+		})
+	}
+}
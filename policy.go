@@ -4,31 +4,275 @@ import (
 	"fmt"
 	"github.com/Knetic/govaluate"
 	"gorm/dbroute/util/str"
+	"math"
+	"reflect"
 	"strconv"
+	"sync"
 )
 
-// parseExpression 分表表达式解析
-func parseExpression(parameter string, expression string, value interface{}) interface{} {
-	functions := map[string]govaluate.ExpressionFunction{
+// ExpressionFunction 分片表达式函数，数值参数为 float64，返回数值时应为 float64 以便参与运算
+type ExpressionFunction func(args ...interface{}) (interface{}, error)
+
+var (
+	expressionFunctionsMu sync.RWMutex
+	// expressionFunctions 分片表达式可用的函数
+	expressionFunctions = map[string]govaluate.ExpressionFunction{
 		"parse": func(args ...interface{}) (interface{}, error) {
 			str := ""
 			for _, arg := range args {
-				str += fmt.Sprintf("%v", arg)
+				str += expressionString(arg)
 			}
 			return str, nil
 		},
 		"hashcode": func(args ...interface{}) (interface{}, error) {
-			return str.Hashcode(fmt.Sprintf("%v", args[0])), nil
-		},
-		"mod": func(args ...interface{}) (interface{}, error) {
-			a, _ := strconv.ParseInt(fmt.Sprintf("%v", args[0]), 10, 64)
-			b, _ := strconv.ParseInt(fmt.Sprintf("%v", args[1]), 10, 64)
-			return a % b, nil
+			if err := checkArgs("hashcode", args, 1, 1); err != nil {
+				return nil, err
+			}
+			return float64(str.Hashcode(expressionString(args[0]))), nil
 		},
+		"mod":        modFunction,
+		"crc32":      crc32Function,
+		"murmur3":    murmur3Function,
+		"md5mod":     md5ModFunction,
+		"substr":     substrFunction,
+		"lpad":       lpadFunction,
+		"dateFormat": dateFormatFunction,
+		"floorDiv":   floorDivFunction,
+		"abs":        absFunction,
+	}
+)
+
+// RegisterExpressionFunction 注册分片表达式函数，同名时覆盖，已编译的表达式重新编译
+func RegisterExpressionFunction(name string, fn ExpressionFunction) {
+	expressionFunctionsMu.Lock()
+	defer expressionFunctionsMu.Unlock()
+	expressionFunctions[name] = govaluate.ExpressionFunction(fn)
+	compiledExpressions.Range(func(key, _ interface{}) bool {
+		compiledExpressions.Delete(key)
+		return true
+	})
+}
+
+// compiledExpressions 预编译的分片表达式，表达式 -> *govaluate.EvaluableExpression
+var compiledExpressions sync.Map
+
+// compileExpression 编译分片表达式，行表达式先转换为分片表达式，同一表达式只编译一次
+func compileExpression(expression string) (*govaluate.EvaluableExpression, error) {
+	if compiled, ok := compiledExpressions.Load(expression); ok {
+		return compiled.(*govaluate.EvaluableExpression), nil
+	}
+	// 持有读锁直到存入缓存，避免与注册函数时清空缓存交错
+	expressionFunctionsMu.RLock()
+	defer expressionFunctionsMu.RUnlock()
+	translated := expression
+	if isInlineExpression(expression) {
+		var err error
+		if translated, err = translateInlineExpression(expression); err != nil {
+			return nil, err
+		}
+	}
+	compiled, err := govaluate.NewEvaluableExpressionWithFunctions(translated, expressionFunctions)
+	if err != nil {
+		return nil, fmt.Errorf("compile sharding expression %q: %w", expression, err)
+	}
+	actual, _ := compiledExpressions.LoadOrStore(expression, compiled)
+	return actual.(*govaluate.EvaluableExpression), nil
+}
+
+// expressionResult 分片表达式的计算结果：字符串为分片名，整数为分片序号
+type expressionResult struct {
+	name    string
+	index   int64
+	isIndex bool
+}
+
+// newExpressionResult 转换表达式的计算结果，小数、布尔值等无法作为分片
+func newExpressionResult(value interface{}) (expressionResult, error) {
+	switch v := value.(type) {
+	case string:
+		if v == "" {
+			return expressionResult{}, fmt.Errorf("empty sharding name")
+		}
+		return expressionResult{name: v}, nil
+	case ShardingName:
+		return newExpressionResult(string(v))
+	case float64:
+		if v != math.Trunc(v) || v < 0 {
+			return expressionResult{}, fmt.Errorf("sharding index must be a non-negative integer, got %v", v)
+		}
+		return expressionResult{index: int64(v), isIndex: true}, nil
+	case int64, int, int32:
+		index, _ := toInt64(v)
+		return newExpressionResult(float64(index))
+	}
+	return expressionResult{}, fmt.Errorf("sharding result must be a name or an index, got %T %v", value, value)
+}
+
+// tableName 物理表名，序号按 表名_序号 命名
+func (r expressionResult) tableName(table string) string {
+	if r.isIndex {
+		return fmt.Sprintf("%v_%v", table, r.index)
+	}
+	return r.name
+}
+
+// databaseName 数据源名，序号为按名称排序的数据源中的位置
+func (r expressionResult) databaseName(names []ShardingName) (ShardingName, error) {
+	if !r.isIndex {
+		return ShardingName(r.name), nil
+	}
+	if r.index >= int64(len(names)) {
+		return "", fmt.Errorf("data source index %d out of range, %d data sources", r.index, len(names))
+	}
+	return names[r.index], nil
+}
+
+// validateExpression
+//
+//	@Description: 编译分片表达式并校验：引用的变量须为分片键，以整数及字符串样例取值计算结果须为分片名或分片序号
+//	@param expression
+//	@param parameters	分片键
+//	@return error
+func validateExpression(expression string, parameters []string) error {
+	compiled, err := compileExpression(expression)
+	if err != nil {
+		return err
+	}
+	known := make(map[string]bool, len(parameters))
+	for _, parameter := range parameters {
+		known[parameter] = true
+	}
+	for _, name := range compiled.Vars() {
+		if !known[name] {
+			return fmt.Errorf("sharding expression %q references %s, which is not a sharding parameter %v", expression, name, parameters)
+		}
+	}
+	var errs []error
+	for _, sample := range []interface{}{int64(1), "1"} {
+		values := make(map[string]interface{}, len(parameters))
+		for _, parameter := range parameters {
+			values[parameter] = sample
+		}
+		result, err := compiled.Evaluate(values)
+		if err != nil {
+			errs = append(errs, err)
+			continue
+		}
+		if _, err = newExpressionResult(result); err != nil {
+			return fmt.Errorf("sharding expression %q: %w", expression, err)
+		}
+		return nil
+	}
+	return fmt.Errorf("evaluate sharding expression %q with sample values: %v", expression, errs[0])
+}
+
+// evaluateExpression 计算分片表达式，parameters 为各分片键的取值
+func evaluateExpression(expression string, parameters map[string]interface{}) (expressionResult, error) {
+	compiled, err := compileExpression(expression)
+	if err != nil {
+		return expressionResult{}, err
+	}
+	if parameters, err = exactParameters(compiled, parameters); err != nil {
+		return expressionResult{}, fmt.Errorf("evaluate sharding expression %q: %w", expression, err)
+	}
+	value, err := compiled.Evaluate(parameters)
+	if err != nil {
+		return expressionResult{}, fmt.Errorf("evaluate sharding expression %q: %w", expression, err)
+	}
+	result, err := newExpressionResult(value)
+	if err != nil {
+		return expressionResult{}, fmt.Errorf("sharding expression %q: %w", expression, err)
+	}
+	return result, nil
+}
+
+// maxExactInteger float64 可精确表示的最大整数 2^53
+const maxExactInteger = 1 << 53
+
+// exactParameters
+//
+//	@Description: 表达式按 float64 计算数值，绝对值超过 2^53 的整数（如雪花主键）会被舍入：直接作为函数参数时
+//	（如 mod(order_id, 16)、crc32(order_id)）转为十进制字符串传入，由函数精确解析；参与运算符计算时返回错误
+//	@param compiled
+//	@param parameters	各分片键的取值
+//	@return map[string]interface{}
+//	@return error
+func exactParameters(compiled *govaluate.EvaluableExpression, parameters map[string]interface{}) (map[string]interface{}, error) {
+	var (
+		exact     map[string]interface{}
+		arguments map[string]bool
+	)
+	for name, value := range parameters {
+		var s string
+		switch rv := reflect.ValueOf(value); rv.Kind() {
+		case reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32, reflect.Int64:
+			if v := rv.Int(); v > maxExactInteger || v < -maxExactInteger {
+				s = strconv.FormatInt(v, 10)
+			}
+		case reflect.Uint, reflect.Uint8, reflect.Uint16, reflect.Uint32, reflect.Uint64:
+			if v := rv.Uint(); v > maxExactInteger {
+				s = strconv.FormatUint(v, 10)
+			}
+		}
+		if s == "" {
+			continue
+		}
+		if arguments == nil {
+			arguments = functionArguments(compiled.Tokens())
+		}
+		if !arguments[name] && referenced(compiled, name) {
+			return nil, fmt.Errorf("%s = %s exceeds ±2^53 and cannot be used with operators, pass it to a function such as mod(%s, n)", name, s, name)
+		}
+		if exact == nil {
+			exact = make(map[string]interface{}, len(parameters))
+			for k, v := range parameters {
+				exact[k] = v
+			}
+		}
+		exact[name] = s
+	}
+	if exact == nil {
+		return parameters, nil
+	}
+	return exact, nil
+}
+
+// referenced 表达式是否引用了变量
+func referenced(compiled *govaluate.EvaluableExpression, name string) bool {
+	for _, v := range compiled.Vars() {
+		if v == name {
+			return true
+		}
+	}
+	return false
+}
+
+// functionArguments 每次出现都直接作为函数参数的变量，如 mod(order_id, 16) 中的 order_id
+func functionArguments(tokens []govaluate.ExpressionToken) map[string]bool {
+	arguments := make(map[string]bool)
+	operand := make(map[string]bool)
+	for i, token := range tokens {
+		if token.Kind != govaluate.VARIABLE {
+			continue
+		}
+		name, _ := token.Value.(string)
+		// 跳过包裹变量的括号，如行表达式 order_${order_id} 转换后的 parse("order_", (order_id))
+		l, r := i-1, i+1
+		for l >= 0 && r < len(tokens) && tokens[l].Kind == govaluate.CLAUSE && tokens[r].Kind == govaluate.CLAUSE_CLOSE &&
+			(l == 0 || tokens[l-1].Kind != govaluate.FUNCTION) {
+			l, r = l-1, r+1
+		}
+		opened := l >= 0 && (tokens[l].Kind == govaluate.SEPARATOR ||
+			tokens[l].Kind == govaluate.CLAUSE && l > 0 && tokens[l-1].Kind == govaluate.FUNCTION)
+		closed := r < len(tokens) && (tokens[r].Kind == govaluate.SEPARATOR || tokens[r].Kind == govaluate.CLAUSE_CLOSE)
+		if opened && closed {
+			arguments[name] = true
+		} else {
+			operand[name] = true
+		}
+	}
+	for name := range operand {
+		delete(arguments, name)
 	}
-	expressionRes, _ := govaluate.NewEvaluableExpressionWithFunctions(expression, functions)
-	parameters := make(map[string]interface{})
-	parameters[parameter] = value
-	result, _ := expressionRes.Evaluate(parameters)
-	return result
+	return arguments
 }
@@ -0,0 +1,104 @@
+package dbroute
+
+import (
+	"fmt"
+	"strings"
+	"testing"
+
+	"gorm.io/gorm"
+	"gorm.io/gorm/logger"
+)
+
+// TestValidateExpression 注册时预编译分片表达式，并以样例取值校验计算结果
+func TestValidateExpression(t *testing.T) {
+	tests := []struct {
+		name       string
+		expression string
+		wantErr    string
+	}{
+		{name: "inline expression", expression: "order_${user_id % 4}"},
+		{name: "index", expression: "user_id % 4"},
+		{name: "hashcode index", expression: "hashcode(user_id) % 4"},
+		{name: "hashcode inline expression", expression: "order_${hashcode(user_id) % 4}"},
+		{name: "unbalanced parenthesis", expression: "order_${(user_id % 4}", wantErr: `compile sharding expression "order_${(user_id % 4}"`},
+		{name: "missing operand", expression: "order_${user_id % }", wantErr: `evaluate sharding expression "order_${user_id % }" with sample values`},
+		{name: "unknown variable", expression: "order_${uid % 4}", wantErr: "references uid, which is not a sharding parameter [user_id]"},
+		{name: "fractional index", expression: "user_id / 3", wantErr: "sharding index must be a non-negative integer"},
+		{name: "boolean result", expression: "user_id > 1", wantErr: "sharding result must be a name or an index"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			err := validateExpression(tt.expression, []string{"user_id"})
+			if tt.wantErr == "" {
+				if err != nil {
+					t.Errorf("validateExpression() error = %v", err)
+				}
+				return
+			}
+			if err == nil || !strings.Contains(err.Error(), tt.wantErr) {
+				t.Errorf("validateExpression() error = %v, want %q", err, tt.wantErr)
+			}
+		})
+	}
+}
+
+// TestEvaluateHashcodeExpression hashcode 的结果可参与取模，得到分片序号或分片名
+func TestEvaluateHashcodeExpression(t *testing.T) {
+	tests := []struct {
+		expression string
+		userID     interface{}
+		want       expressionResult
+	}{
+		{expression: "hashcode(user_id) % 4", userID: int64(12), want: expressionResult{index: 1, isIndex: true}},
+		{expression: "hashcode(user_id) % 4", userID: "13", want: expressionResult{index: 2, isIndex: true}},
+		{expression: "order_${hashcode(user_id) % 4}", userID: int64(12), want: expressionResult{name: "order_1"}},
+		{expression: "order_${hashcode(user_id) % 4}", userID: int64(1234567), want: expressionResult{name: "order_0"}},
+	}
+	for _, tt := range tests {
+		t.Run(fmt.Sprint(tt.expression, " ", tt.userID), func(t *testing.T) {
+			got, err := evaluateExpression(tt.expression, map[string]interface{}{"user_id": tt.userID})
+			if err != nil {
+				t.Fatal(err)
+			}
+			if got != tt.want {
+				t.Errorf("evaluateExpression() = %+v, want %+v", got, tt.want)
+			}
+		})
+	}
+}
+
+// TestRegisterExpressionError 初始化时分片表达式错误由 Use 返回，初始化后注册的错误由 RegisterError 及该表上的语句返回
+func TestRegisterExpressionError(t *testing.T) {
+	rules := func(expression string) *TbShardingRoutePolicy {
+		return &TbShardingRoutePolicy{DataShardingRuleModelMap: map[string]DataShardingRuleModel{"test_orders": {
+			Table:                   "test_orders",
+			TableShardingParameter:  "user_id",
+			TableShardingExpression: expression,
+		}}}
+	}
+	const wantErr = `route of [test_orders]: sharding rule of test_orders: table sharding: sharding expression "test_orders_${uid % 2}" references uid, which is not a sharding parameter [user_id]`
+
+	db, err := gorm.Open(testDialector{pool: &testConnPool{name: "default"}}, &gorm.Config{Logger: logger.Discard})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err = db.Use(Register(Config{TbPolicy: rules("test_orders_${uid % 2}")}, "test_orders")); err == nil || err.Error() != wantErr {
+		t.Fatalf("Use() error = %v, want %s", err, wantErr)
+	}
+
+	db, err = gorm.Open(testDialector{pool: &testConnPool{name: "default"}}, &gorm.Config{Logger: logger.Discard})
+	if err != nil {
+		t.Fatal(err)
+	}
+	dr := Register(Config{TbPolicy: rules("test_orders_${user_id % 2}")}, "test_users")
+	if err = db.Use(dr); err != nil {
+		t.Fatal(err)
+	}
+	dr.Register(Config{TbPolicy: rules("test_orders_${uid % 2}")}, "test_orders")
+	if err = dr.RegisterError(); err == nil || err.Error() != wantErr {
+		t.Errorf("RegisterError() = %v, want %s", err, wantErr)
+	}
+	if err = db.Create(&testOrder{ID: 1, UserID: 1}).Error; err == nil || err.Error() != wantErr {
+		t.Errorf("Create() error = %v, want %s", err, wantErr)
+	}
+}
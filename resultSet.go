@@ -0,0 +1,162 @@
+package dbroute
+
+import (
+	"context"
+	"database/sql"
+	"database/sql/driver"
+	"errors"
+	"io"
+	"reflect"
+	"sync"
+)
+
+var (
+	resultSetOnce sync.Once
+	resultSetPool *sql.DB
+)
+
+// resultSet 内存中的查询结果，分散查询时用于归并各数据节点的数据
+type resultSet struct {
+	columns   []string
+	scanTypes []reflect.Type
+	dbTypes   []string
+	rows      [][]driver.Value
+}
+
+// readResultSet 读取全部行到内存
+func readResultSet(rows *sql.Rows) (*resultSet, error) {
+	columns, err := rows.Columns()
+	if err != nil {
+		return nil, err
+	}
+	rs := &resultSet{columns: columns}
+	if columnTypes, err := rows.ColumnTypes(); err == nil {
+		for _, columnType := range columnTypes {
+			rs.scanTypes = append(rs.scanTypes, columnType.ScanType())
+			rs.dbTypes = append(rs.dbTypes, columnType.DatabaseTypeName())
+		}
+	}
+	for rows.Next() {
+		values := make([]interface{}, len(columns))
+		dest := make([]interface{}, len(columns))
+		for i := range values {
+			dest[i] = &values[i]
+		}
+		if err := rows.Scan(dest...); err != nil {
+			return nil, err
+		}
+		row := make([]driver.Value, len(columns))
+		for i, value := range values {
+			row[i] = value
+		}
+		rs.rows = append(rs.rows, row)
+	}
+	return rs, rows.Err()
+}
+
+// concatResultSets 按顺序拼接多个结果
+func concatResultSets(sets []*resultSet) *resultSet {
+	rs := &resultSet{}
+	for _, set := range sets {
+		if set == nil {
+			continue
+		}
+		if rs.columns == nil {
+			rs.columns, rs.scanTypes, rs.dbTypes = set.columns, set.scanTypes, set.dbTypes
+		}
+		rs.rows = append(rs.rows, set.rows...)
+	}
+	return rs
+}
+
+// resultSetDB 以内存结果为数据源的 sql.DB，用于构造 gorm 需要的 *sql.Rows、*sql.Row
+func resultSetDB() *sql.DB {
+	resultSetOnce.Do(func() {
+		resultSetPool = sql.OpenDB(resultSetConnector{})
+	})
+	return resultSetPool
+}
+
+type resultSetConnector struct{}
+
+func (resultSetConnector) Connect(context.Context) (driver.Conn, error) {
+	return resultSetConn{}, nil
+}
+
+func (resultSetConnector) Driver() driver.Driver {
+	return resultSetDriver{}
+}
+
+type resultSetDriver struct{}
+
+func (resultSetDriver) Open(string) (driver.Conn, error) {
+	return resultSetConn{}, nil
+}
+
+type resultSetConn struct{}
+
+func (resultSetConn) Prepare(string) (driver.Stmt, error) {
+	return nil, errors.New("result set: prepare not supported")
+}
+
+func (resultSetConn) Close() error {
+	return nil
+}
+
+func (resultSetConn) Begin() (driver.Tx, error) {
+	return nil, errors.New("result set: transaction not supported")
+}
+
+// CheckNamedValue 参数原样传递给 QueryContext
+func (resultSetConn) CheckNamedValue(*driver.NamedValue) error {
+	return nil
+}
+
+// QueryContext 参数为 *resultSet 时返回其数据，为 error 时返回该错误
+func (resultSetConn) QueryContext(_ context.Context, _ string, args []driver.NamedValue) (driver.Rows, error) {
+	if len(args) == 1 {
+		switch v := args[0].Value.(type) {
+		case *resultSet:
+			return &resultSetRows{rs: v}, nil
+		case error:
+			return nil, v
+		}
+	}
+	return nil, errors.New("result set: invalid query arguments")
+}
+
+type resultSetRows struct {
+	rs    *resultSet
+	index int
+}
+
+func (r *resultSetRows) Columns() []string {
+	return r.rs.columns
+}
+
+func (r *resultSetRows) Close() error {
+	return nil
+}
+
+func (r *resultSetRows) Next(dest []driver.Value) error {
+	if r.index >= len(r.rs.rows) {
+		return io.EOF
+	}
+	copy(dest, r.rs.rows[r.index])
+	r.index++
+	return nil
+}
+
+func (r *resultSetRows) ColumnTypeScanType(index int) reflect.Type {
+	if index < len(r.rs.scanTypes) && r.rs.scanTypes[index] != nil {
+		return r.rs.scanTypes[index]
+	}
+	return reflect.TypeOf(new(interface{})).Elem()
+}
+
+func (r *resultSetRows) ColumnTypeDatabaseTypeName(index int) string {
+	if index < len(r.rs.dbTypes) {
+		return r.rs.dbTypes[index]
+	}
+	return ""
+}
@@ -1,16 +1,26 @@
 package dbroute
 
 import (
+	"fmt"
+	"github.com/xwb1989/sqlparser"
 	"gorm.io/gorm"
+	"strings"
+	"sync"
 )
 
 type route struct {
-	masters               map[ShardingName][]gorm.ConnPool
-	slaves                map[ShardingName][]gorm.ConnPool
-	dbPolicy              DbPolicy
-	tbPolicy              TbPolicy
-	dbRoute               *DBRoute
-	traceRouteMode        bool
+	masters         map[ShardingName][]gorm.ConnPool
+	slaves          map[ShardingName][]gorm.ConnPool
+	dbPolicy        DbPolicy
+	tbPolicy        TbPolicy
+	dbRoute         *DBRoute
+	traceRouteMode  bool
+	bindingTables   map[string][]string
+	broadcast       bool
+	autoCreateTable bool
+	keyGenerator    KeyGenerator
+	// 已创建的物理表，数据源.表名 -> *tableCreation
+	createdTables         sync.Map
 	DataShardingRuleModel DataShardingRuleModel
 }
 
@@ -31,42 +41,419 @@ func (r *route) mark(stmt *gorm.Statement, shardingName ShardingName) {
 	}
 }
 
-// rewriteSql
+// routeUnit 路由单元，一个数据节点上待执行的sql
+type routeUnit struct {
+	Name     ShardingName
+	ConnPool gorm.ConnPool
+	Table    string
+	Sql      string
+	// 拆分多行插入时该节点的参数及对应的原始行序号，为空时使用语句原参数
+	Vars []interface{}
+	Rows []int
+}
+
+// resolve
 //
-//	@Description: 重写sql，分表实现
+//	@Description: 分库分表路由，返回全部需要执行的数据节点，多个节点的查询同时返回归并计划
 //	@param stmt
-//	@param sql	带占位符的sql
-//	@return string
-func (r *route) rewriteSql(stmt *gorm.Statement, sql string) string {
-	ctx := stmt.Context
-	tbPolicyResult := r.tbPolicy.Resolve(ctx, stmt.Table, sql, stmt.Logger)
-	return tbPolicyResult.Sql
+//	@param sql	带占位符的sql，用于改写表名
+//	@param explainSql	填充了参数值的sql，用于解析分片键
+//	@param op
+//	@return units
+//	@return plan
+//	@return err
+func (r *route) resolve(stmt *gorm.Statement, sql string, explainSql string, op Operation) (units []routeUnit, plan *mergePlan, err error) {
+	if r.broadcast {
+		return r.resolveBroadcast(stmt, sql, op), nil, nil
+	}
+	sql, returning := splitReturning(stmt, sql)
+	if returning != "" {
+		explainSql, _ = splitReturning(stmt, explainSql)
+		// 改写后的各数据节点语句追加原 RETURNING 子句
+		defer func() {
+			for i := range units {
+				units[i].Sql += returning
+			}
+		}()
+	}
+	tbResult := r.tbPolicy.Resolve(stmt.Context, stmt.Table, explainSql, stmt.Logger)
+	dbResult := r.dbPolicy.Resolve(stmt.Context, r.connPools(op), stmt.Table, explainSql, stmt.Logger)
+	if err = policyError(tbResult, dbResult); err != nil {
+		return nil, nil, err
+	}
+	r.mark(stmt, dbResult.Name)
+
+	tables, targets := tbResult.tables(), dbResult.targets()
+	if len(tables)*len(targets) > 1 {
+		if node, err := sqlparser.Parse(sql); err == nil {
+			if insert, ok := node.(*sqlparser.Insert); ok {
+				if rows, ok := insert.Rows.(sqlparser.Values); ok && len(rows) > 1 {
+					units, err = r.resolveInsert(stmt, insert, explainSql, op)
+					return units, nil, err
+				}
+			}
+		}
+		if sql, plan, err = scatterSql(stmt, sql, len(tables)*len(targets)); err != nil {
+			return nil, nil, err
+		}
+	}
+
+	references, err := r.resolveReferences(stmt, explainSql, targets, op)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	// 分表改写后的sql，各数据源共用
+	sqls := make(map[string]string)
+	for _, table := range tables {
+		tableNames, err := r.bindingTableNames(stmt.Table, table)
+		if err != nil {
+			return nil, nil, err
+		}
+		switch {
+		case table == "" && len(references) == 0:
+			sqls[table] = sql
+		case tableNames == nil && len(references) == 0:
+			if sqls[table], err = ChangeSqlTableName(sql, table); err != nil {
+				return nil, nil, err
+			}
+		default:
+			if tableNames == nil {
+				tableNames = make(map[string]string)
+				if table != "" {
+					tableNames[stmt.Table] = table
+				}
+			}
+			for name, actualTable := range references {
+				tableNames[name] = actualTable
+			}
+			if sqls[table], err = ChangeSqlTableNames(sql, tableNames); err != nil {
+				return nil, nil, err
+			}
+		}
+	}
+	for _, target := range targets {
+		connPool := r.prepared(stmt, target.ConnPool)
+		for _, table := range tables {
+			units = append(units, routeUnit{Name: target.Name, ConnPool: connPool, Table: table, Sql: sqls[table]})
+		}
+	}
+	return units, plan, nil
+}
+
+// resolveBroadcast 广播表读操作随机选取一个连接池，写操作在每个主库执行
+func (r *route) resolveBroadcast(stmt *gorm.Statement, sql string, op Operation) (units []routeUnit) {
+	if op == Read {
+		result := DbRandomPolicy{}.Resolve(stmt.Context, r.connPools(op), stmt.Table, sql, stmt.Logger)
+		r.mark(stmt, result.Name)
+		return []routeUnit{{Name: result.Name, ConnPool: r.prepared(stmt, result.ConnPool), Sql: sql}}
+	}
+	for _, target := range scatterPolicyResult(r.masters).targets() {
+		units = append(units, routeUnit{Name: target.Name, ConnPool: r.prepared(stmt, target.ConnPool), Sql: sql})
+	}
+	r.mark(stmt, units[0].Name)
+	return units
 }
 
-// route
+// resolveInsert
 //
-//	@Description: 路由指定实例，分库实现
+//	@Description: 多行插入按每行的分片键分组，每组生成一条只含该组行的插入语句
 //	@param stmt
-//	@param sql 填充了参数值的sql
+//	@param node	带占位符的插入语句
+//	@param explainSql	填充了参数值的sql，用于解析每行的分片键
 //	@param op
-//	@return connPool
-func (r *route) route(stmt *gorm.Statement, sql string, op Operation) (connPool gorm.ConnPool) {
-	if op == Read {
-		if r.slaves != nil {
-			result := r.dbPolicy.Resolve(stmt.Context, r.slaves, stmt.Table, sql, stmt.Logger)
-			connPool = result.ConnPool
-			r.mark(stmt, result.Name)
-		} else {
-			result := r.dbPolicy.Resolve(stmt.Context, r.masters, stmt.Table, sql, stmt.Logger)
-			connPool = result.ConnPool
-			r.mark(stmt, result.Name)
+//	@return units
+//	@return err
+func (r *route) resolveInsert(stmt *gorm.Statement, node *sqlparser.Insert, explainSql string, op Operation) (units []routeUnit, err error) {
+	explainNode, err := sqlparser.Parse(explainSql)
+	if err != nil {
+		return nil, err
+	}
+	explainInsert, ok := explainNode.(*sqlparser.Insert)
+	if !ok {
+		return nil, fmt.Errorf("unexpected explain sql: %s", explainSql)
+	}
+	rows, explainRows := node.Rows.(sqlparser.Values), explainInsert.Rows.(sqlparser.Values)
+	if len(rows) != len(explainRows) {
+		return nil, fmt.Errorf("insert rows mismatch: %d != %d", len(rows), len(explainRows))
+	}
+	nodes := make([]sqlparser.SQLNode, 0, len(rows)+1)
+	for _, row := range rows {
+		nodes = append(nodes, row)
+	}
+	// 最后一项为 ON DUPLICATE KEY UPDATE 中的参数，各组共用
+	rowArgs, err := placeholderArgs(append(nodes, node.OnDup)...)
+	if err != nil {
+		return nil, err
+	}
+
+	indexes := make(map[string]int)
+	for i, row := range explainRows {
+		single := *explainInsert
+		single.Rows = sqlparser.Values{row}
+		rowSql := sqlparser.String(&single)
+		tbResult := r.tbPolicy.Resolve(stmt.Context, stmt.Table, rowSql, stmt.Logger)
+		dbResult := r.dbPolicy.Resolve(stmt.Context, r.connPools(op), stmt.Table, rowSql, stmt.Logger)
+		if err = policyError(tbResult, dbResult); err != nil {
+			return nil, err
 		}
-	} else {
-		result := r.dbPolicy.Resolve(stmt.Context, r.masters, stmt.Table, sql, stmt.Logger)
-		connPool = result.ConnPool
-		r.mark(stmt, result.Name)
+		if len(tbResult.Scatter) > 0 || len(dbResult.Scatter) > 0 {
+			return nil, fmt.Errorf("sharding value not found in row %d of insert into %s", i+1, stmt.Table)
+		}
+		key := string(dbResult.Name) + "." + tbResult.ActualTableName
+		index, ok := indexes[key]
+		if !ok {
+			index = len(units)
+			indexes[key] = index
+			units = append(units, routeUnit{Name: dbResult.Name, ConnPool: r.prepared(stmt, dbResult.ConnPool), Table: tbResult.ActualTableName})
+		}
+		units[index].Rows = append(units[index].Rows, i)
+	}
+	r.mark(stmt, units[0].Name)
+
+	for i, unit := range units {
+		insert := *node
+		values := make(sqlparser.Values, 0, len(unit.Rows))
+		vars := make([]interface{}, 0, len(stmt.Vars))
+		for _, row := range unit.Rows {
+			values = append(values, rows[row])
+			for _, arg := range rowArgs[row] {
+				vars = append(vars, stmt.Vars[arg])
+			}
+		}
+		for _, arg := range rowArgs[len(rows)] {
+			vars = append(vars, stmt.Vars[arg])
+		}
+		insert.Rows = values
+		units[i].Sql = restorePlaceholder(sqlparser.String(&insert))
+		if unit.Table != "" {
+			var err error
+			if units[i].Sql, err = ChangeSqlTableName(units[i].Sql, unit.Table); err != nil {
+				return nil, err
+			}
+		}
+		units[i].Vars = vars
+	}
+	if len(units) == 1 {
+		// 全部行位于同一节点，按原语句执行
+		units[0].Vars, units[0].Rows = nil, nil
+	}
+	return units, nil
+}
+
+// splitReturning 拆出 gorm 追加在语句末尾的 RETURNING 子句，sqlparser 无法解析该子句
+func splitReturning(stmt *gorm.Statement, sql string) (string, string) {
+	if _, ok := stmt.Clauses["RETURNING"]; !ok {
+		return sql, ""
+	}
+	if i := strings.LastIndex(sql, " RETURNING "); i >= 0 {
+		return sql[:i], sql[i:]
+	}
+	return sql, ""
+}
+
+// policyError 分表、分库策略的错误
+func policyError(tbResult TbPolicyResult, dbResult DbPolicyResult) error {
+	if tbResult.Error != nil {
+		return tbResult.Error
+	}
+	return dbResult.Error
+}
+
+// scatterSql 分散执行前的校验与改写，查询语句生成归并计划
+func scatterSql(stmt *gorm.Statement, sql string, nodes int) (string, *mergePlan, error) {
+	node, err := sqlparser.Parse(sql)
+	if err != nil {
+		return sql, nil, nil
 	}
+	switch node := node.(type) {
+	case *sqlparser.Insert:
+		return sql, nil, fmt.Errorf("sharding value not found, insert into %s would be scattered to %d data nodes", stmt.Table, nodes)
+	case *sqlparser.Select:
+		plan, err := newMergePlan(node)
+		if err != nil {
+			return sql, nil, err
+		}
+		return restorePlaceholder(sqlparser.String(node)), plan, nil
+	}
+	return sql, nil, nil
+}
+
+// resolveReferences
+//
+//	@Description: 解析语句中主表及其绑定表以外的表引用（子查询、派生表、UNION 分支、INSERT ... SELECT），
+//	按各表注册的路由及所在查询的条件确定物理表，分库的表须与主表路由到相同的数据源
+//	@param stmt
+//	@param explainSql
+//	@param targets	主表路由到的数据源
+//	@param op
+//	@return tableNames	各表对应的物理表
+//	@return err
+func (r *route) resolveReferences(stmt *gorm.Statement, explainSql string, targets []DbPolicyResult, op Operation) (tableNames map[string]string, err error) {
+	node, err := sqlparser.Parse(explainSql)
+	if err != nil {
+		return nil, nil
+	}
+	for table, sel := range tableSelects(node) {
+		if table == stmt.Table || r.isBinding(stmt.Table, table) {
+			continue
+		}
+		ref, ok := r.dbRoute.routes[table]
+		if !ok || ref.broadcast {
+			continue
+		}
+		selectSql := sqlparser.String(sel)
+		tbResult := ref.tbPolicy.Resolve(stmt.Context, table, selectSql, stmt.Logger)
+		if tbResult.Error != nil {
+			return nil, tbResult.Error
+		}
+		if len(tbResult.Scatter) > 0 {
+			return nil, fmt.Errorf("table %s referenced by %s cannot be routed to a single actual table", table, stmt.Table)
+		}
+		if tbResult.ActualTableName != "" && tbResult.ActualTableName != table {
+			if tableNames == nil {
+				tableNames = make(map[string]string)
+			}
+			tableNames[table] = tbResult.ActualTableName
+		}
+		if _, random := ref.dbPolicy.(DbRandomPolicy); random {
+			// 未分库的表不限制数据源
+			continue
+		}
+		dbResult := ref.dbPolicy.Resolve(stmt.Context, ref.connPools(op), table, selectSql, stmt.Logger)
+		if dbResult.Error != nil {
+			return nil, dbResult.Error
+		}
+		if err = colocated(dbResult, targets); err != nil {
+			return nil, fmt.Errorf("table %s referenced by %s: %w", table, stmt.Table, err)
+		}
+	}
+	return tableNames, nil
+}
+
+// colocated
+//
+//	@Description: 引用的表在每个数据节点上与主表位于相同数据源：引用的表路由到单个数据源时主表须路由到同一数据源；
+//	引用的表未命中分库键时，主表须分散到其全部数据源，各节点在本数据源内执行引用的表
+//	@param dbResult	引用的表的分库结果
+//	@param targets	主表路由到的数据源
+//	@return error
+func colocated(dbResult DbPolicyResult, targets []DbPolicyResult) error {
+	refTargets := dbResult.targets()
+	names := make(map[ShardingName]bool, len(refTargets))
+	for _, target := range refTargets {
+		names[target.Name] = true
+	}
+	for _, target := range targets {
+		if !names[target.Name] && len(dbResult.Scatter) > 0 {
+			return fmt.Errorf("scatters to data nodes without %s", target.Name)
+		} else if !names[target.Name] {
+			return fmt.Errorf("targets data node %s, different from %s", dbResult.Name, target.Name)
+		}
+	}
+	if len(refTargets) > len(targets) {
+		return fmt.Errorf("scatters to %d data nodes, more than %d", len(refTargets), len(targets))
+	}
+	return nil
+}
+
+// bindingTableNames
+//
+//	@Description: 绑定表组内各表对应的物理表：取主表物理表在其全部物理表中的位置，各绑定表按自身路由的分表策略列出全部物理表，
+//	对应相同位置的物理表，如 order_3 对应 order_item_3
+//	@param table
+//	@param actualTable	主表路由到的物理表
+//	@return map[string]string
+//	@return error	绑定表无法列出物理表或物理表数量与主表不一致时返回错误
+func (r *route) bindingTableNames(table string, actualTable string) (map[string]string, error) {
+	group, ok := r.bindingTables[table]
+	if !ok || actualTable == "" {
+		return nil, nil
+	}
+	actualTables := r.actualTables(table)
+	index := -1
+	for i, name := range actualTables {
+		if name == actualTable {
+			index = i
+			break
+		}
+	}
+	if index < 0 {
+		return nil, fmt.Errorf("actual table %s is not listed in actual tables of %s", actualTable, table)
+	}
+	tableNames := make(map[string]string, len(group))
+	for _, binding := range group {
+		ref := r
+		if other, ok := r.dbRoute.routes[binding]; ok {
+			ref = other
+		}
+		bindingTables := ref.actualTables(binding)
+		if len(bindingTables) != len(actualTables) {
+			return nil, fmt.Errorf("binding table %s has %d actual tables, %s has %d", binding, len(bindingTables), table, len(actualTables))
+		}
+		tableNames[binding] = bindingTables[index]
+	}
+	return tableNames, nil
+}
+
+// validateBindingTables 校验绑定表组内各表按自身路由列出的物理表能够一一对应
+func (r *route) validateBindingTables() error {
+	for table := range r.bindingTables {
+		if actualTables := r.actualTables(table); len(actualTables) > 0 {
+			if _, err := r.bindingTableNames(table, actualTables[0]); err != nil {
+				return err
+			}
+		}
+	}
+	return nil
+}
+
+// isBinding 两表是否属于同一绑定表组
+func (r *route) isBinding(table string, other string) bool {
+	for _, binding := range r.bindingTables[table] {
+		if binding == other {
+			return true
+		}
+	}
+	return false
+}
+
+// sharded 是否按分片路由，未配置分库、分表策略且非广播的路由只切换数据源
+func (r *route) sharded() bool {
+	if r.broadcast {
+		return true
+	}
+	switch r.dbPolicy.(type) {
+	case DbRandomPolicy, *DbRandomPolicy:
+	default:
+		return true
+	}
+	switch r.tbPolicy.(type) {
+	case TbDefaultPolicy, *TbDefaultPolicy:
+		return false
+	}
+	return true
+}
+
+// defaultMasterOnly 是否仅以默认连接为主库（未配置 Masters），此时开启在默认连接上的事务中可按分表改写
+func (r *route) defaultMasterOnly() bool {
+	if r.broadcast || len(r.masters) != 1 {
+		return false
+	}
+	pools := r.masters[Default]
+	return len(pools) == 1 && pools[0] == unwrapPrepared(r.dbRoute.DB.Config.ConnPool)
+}
+
+// connPools 读操作优先使用从库
+func (r *route) connPools(op Operation) map[ShardingName][]gorm.ConnPool {
+	if op == Read && r.slaves != nil {
+		return r.slaves
+	}
+	return r.masters
+}
 
+// prepared 开启预编译时使用对应连接池的预编译缓存
+func (r *route) prepared(stmt *gorm.Statement, connPool gorm.ConnPool) gorm.ConnPool {
 	if stmt.DB.PrepareStmt {
 		if preparedStmt, ok := r.dbRoute.prepareStmtStore[connPool]; ok {
 			return &gorm.PreparedStmtDB{
@@ -76,7 +463,7 @@ func (r *route) route(stmt *gorm.Statement, sql string, op Operation) (connPool
 			}
 		}
 	}
-	return
+	return connPool
 }
 
 func (r *route) call(fc func(connPool gorm.ConnPool) error) error {
@@ -0,0 +1,432 @@
+package dbroute
+
+import (
+	"context"
+	"database/sql"
+	"reflect"
+	"testing"
+
+	"github.com/xwb1989/sqlparser"
+	"gorm.io/gorm"
+	"gorm.io/gorm/logger"
+)
+
+// testConnPool 不连接数据库的连接池，仅用于区分路由结果
+type testConnPool struct {
+	name string
+}
+
+func (p *testConnPool) PrepareContext(context.Context, string) (*sql.Stmt, error) {
+	return nil, nil
+}
+
+func (p *testConnPool) ExecContext(context.Context, string, ...interface{}) (sql.Result, error) {
+	return nil, nil
+}
+
+func (p *testConnPool) QueryContext(context.Context, string, ...interface{}) (*sql.Rows, error) {
+	return nil, nil
+}
+
+func (p *testConnPool) QueryRowContext(context.Context, string, ...interface{}) *sql.Row {
+	return nil
+}
+
+// testInsertRoute 按 user_id % 2 分库、floorDiv(user_id, 2) % 2 分表的路由
+func testInsertRoute() *route {
+	rules := map[string]DataShardingRuleModel{"order": {
+		Table:                      "order",
+		DatabaseShardingParameter:  "user_id",
+		DatabaseShardingExpression: "ds_${user_id % 2}",
+		TableShardingParameter:     "user_id",
+		TableShardingExpression:    "order_${floorDiv(user_id, 2) % 2}",
+		ActualTables:               []string{"order_0", "order_1"},
+	}}
+	return &route{
+		masters: map[ShardingName][]gorm.ConnPool{
+			"ds_0": {&testConnPool{name: "ds_0"}},
+			"ds_1": {&testConnPool{name: "ds_1"}},
+		},
+		dbPolicy: &DbShardingRoutePolicy{DataShardingRuleModelMap: rules},
+		tbPolicy: &TbShardingRoutePolicy{DataShardingRuleModelMap: rules},
+		dbRoute:  &DBRoute{},
+	}
+}
+
+func TestResolveInsert(t *testing.T) {
+	type unit struct {
+		node string
+		rows []int
+		vars []interface{}
+	}
+	tests := []struct {
+		name    string
+		userIDs []int64
+		want    []unit
+		wantErr bool
+	}{
+		{
+			name:    "single node keeps statement",
+			userIDs: []int64{1, 5},
+			want:    []unit{{node: "ds_1.order_0"}},
+		},
+		{
+			name:    "rows split by node in first appearance order",
+			userIDs: []int64{1, 2, 3, 4, 5, 6},
+			want: []unit{
+				{node: "ds_1.order_0", rows: []int{0, 4}, vars: []interface{}{int64(1), int64(10), int64(5), int64(50)}},
+				{node: "ds_0.order_1", rows: []int{1, 5}, vars: []interface{}{int64(2), int64(20), int64(6), int64(60)}},
+				{node: "ds_1.order_1", rows: []int{2}, vars: []interface{}{int64(3), int64(30)}},
+				{node: "ds_0.order_0", rows: []int{3}, vars: []interface{}{int64(4), int64(40)}},
+			},
+		},
+		{
+			name:    "row without sharding value",
+			userIDs: []int64{1, -1},
+			wantErr: true,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			r := testInsertRoute()
+			sql := "INSERT INTO `order` (`user_id`,`amount`) VALUES "
+			explainSql := sql
+			var vars []interface{}
+			for i, userID := range tt.userIDs {
+				if i > 0 {
+					sql += ","
+					explainSql += ","
+				}
+				sql += "(?,?)"
+				if userID < 0 {
+					explainSql += "(NULL," + "0)"
+					vars = append(vars, nil, int64(0))
+					continue
+				}
+				explainSql += logger.ExplainSQL("(?,?)", nil, "'", userID, userID*10)
+				vars = append(vars, userID, userID*10)
+			}
+			node, err := sqlparser.Parse(sql)
+			if err != nil {
+				t.Fatal(err)
+			}
+			stmt := &gorm.Statement{DB: &gorm.DB{Config: &gorm.Config{}}, Context: context.Background(), Table: "order", Vars: vars}
+			stmt.Logger = logger.Discard
+			units, err := r.resolveInsert(stmt, node.(*sqlparser.Insert), explainSql, Write)
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("resolveInsert() error = %v, wantErr %v", err, tt.wantErr)
+			}
+			if tt.wantErr {
+				return
+			}
+			if len(units) != len(tt.want) {
+				t.Fatalf("resolveInsert() units = %d, want %d", len(units), len(tt.want))
+			}
+			for i, want := range tt.want {
+				got := units[i]
+				if node := string(got.Name) + "." + got.Table; node != want.node {
+					t.Errorf("unit %d node = %s, want %s", i, node, want.node)
+				}
+				if got.ConnPool.(*testConnPool).name != string(got.Name) {
+					t.Errorf("unit %d conn pool = %s, want %s", i, got.ConnPool.(*testConnPool).name, got.Name)
+				}
+				if !reflect.DeepEqual(got.Rows, want.rows) {
+					t.Errorf("unit %d rows = %v, want %v", i, got.Rows, want.rows)
+				}
+				if !reflect.DeepEqual(got.Vars, want.vars) {
+					t.Errorf("unit %d vars = %v, want %v", i, got.Vars, want.vars)
+				}
+				if rows := len(want.rows); rows > 0 {
+					wantSql := "insert into " + want.node[len("ds_0."):] + "(user_id, amount) values (?, ?)"
+					for j := 1; j < rows; j++ {
+						wantSql += ", (?, ?)"
+					}
+					if got.Sql != wantSql {
+						t.Errorf("unit %d sql = %s, want %s", i, got.Sql, wantSql)
+					}
+				}
+			}
+		})
+	}
+}
+
+// testBindingRoute order 与 order_item 绑定，按 user_id % 2 分表，itemTables 为 order_item 的物理表
+func testBindingRoute(itemTables ...string) *route {
+	rules := map[string]DataShardingRuleModel{
+		"order": {
+			Table:                   "order",
+			TableShardingParameter:  "user_id",
+			TableShardingExpression: "order_${user_id % 2}",
+			ActualTables:            []string{"order_0", "order_1"},
+		},
+		"order_item": {Table: "order_item", ActualTables: itemTables},
+	}
+	group := []string{"order", "order_item"}
+	return &route{
+		masters:       map[ShardingName][]gorm.ConnPool{"ds_0": {&testConnPool{name: "ds_0"}}},
+		dbPolicy:      DbRandomPolicy{},
+		tbPolicy:      &TbShardingRoutePolicy{DataShardingRuleModelMap: rules},
+		dbRoute:       &DBRoute{routes: map[string]*route{}},
+		bindingTables: map[string][]string{"order": group, "order_item": group},
+	}
+}
+
+func TestBindingTableNames(t *testing.T) {
+	tests := []struct {
+		name        string
+		route       *route
+		table       string
+		actualTable string
+		want        map[string]string
+		wantErr     bool
+	}{
+		{
+			name:        "same position",
+			route:       testBindingRoute("order_item_0", "order_item_1"),
+			table:       "order",
+			actualTable: "order_1",
+			want:        map[string]string{"order": "order_1", "order_item": "order_item_1"},
+		},
+		{
+			name:        "from binding table",
+			route:       testBindingRoute("order_item_0", "order_item_1"),
+			table:       "order_item",
+			actualTable: "order_item_0",
+			want:        map[string]string{"order": "order_0", "order_item": "order_item_0"},
+		},
+		{
+			name:        "not binding",
+			route:       testBindingRoute("order_item_0", "order_item_1"),
+			table:       "user",
+			actualTable: "user_1",
+		},
+		{
+			name:        "actual table not listed",
+			route:       testBindingRoute("order_item_0", "order_item_1"),
+			table:       "order",
+			actualTable: "order_9",
+			wantErr:     true,
+		},
+		{
+			name:        "actual table count mismatch",
+			route:       testBindingRoute("order_item_0", "order_item_1", "order_item_2"),
+			table:       "order",
+			actualTable: "order_0",
+			wantErr:     true,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, err := tt.route.bindingTableNames(tt.table, tt.actualTable)
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("bindingTableNames() error = %v, wantErr %v", err, tt.wantErr)
+			}
+			if !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("bindingTableNames() = %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
+
+// TestBindingTableNamesOtherRoute 绑定表由其它配置注册时按该配置的分表策略列出物理表
+func TestBindingTableNamesOtherRoute(t *testing.T) {
+	r := testBindingRoute()
+	item := testBindingRoute("item_a", "item_b")
+	r.dbRoute.routes["order_item"] = item
+	got, err := r.bindingTableNames("order", "order_1")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if want := map[string]string{"order": "order_1", "order_item": "item_b"}; !reflect.DeepEqual(got, want) {
+		t.Errorf("bindingTableNames() = %v, want %v", got, want)
+	}
+}
+
+func TestValidateBindingTables(t *testing.T) {
+	tests := []struct {
+		name       string
+		itemTables []string
+		wantErr    bool
+	}{
+		{name: "one to one", itemTables: []string{"order_item_0", "order_item_1"}},
+		{name: "more actual tables", itemTables: []string{"order_item_0", "order_item_1", "order_item_2"}, wantErr: true},
+		{name: "fewer actual tables", itemTables: []string{"order_item"}, wantErr: true},
+		{name: "actual tables not configured", wantErr: true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if err := testBindingRoute(tt.itemTables...).validateBindingTables(); (err != nil) != tt.wantErr {
+				t.Errorf("validateBindingTables() error = %v, wantErr %v", err, tt.wantErr)
+			}
+		})
+	}
+}
+
+// TestResolveBindingJoin 绑定表关联查询按主表物理表的位置改写各表，分散时按分片一一关联
+func TestResolveBindingJoin(t *testing.T) {
+	tests := []struct {
+		name string
+		sql  string
+		want []string
+	}{
+		{
+			name: "routed",
+			sql:  "SELECT `order_item`.`sku` FROM `order` JOIN `order_item` ON `order`.`id` = `order_item`.`order_id` WHERE `order`.`user_id` = 3",
+			want: []string{"select order_item_1.sku from order_1 join order_item_1 on order_1.id = order_item_1.order_id where order_1.user_id = 3"},
+		},
+		{
+			name: "scatter",
+			sql:  "SELECT `order_item`.`sku` FROM `order` JOIN `order_item` ON `order`.`id` = `order_item`.`order_id`",
+			want: []string{
+				"select order_item_0.sku from order_0 join order_item_0 on order_0.id = order_item_0.order_id",
+				"select order_item_1.sku from order_1 join order_item_1 on order_1.id = order_item_1.order_id",
+			},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			r := testBindingRoute("order_item_0", "order_item_1")
+			stmt := &gorm.Statement{DB: &gorm.DB{Config: &gorm.Config{}}, Context: context.Background(), Table: "order"}
+			stmt.Logger = logger.Discard
+			units, _, err := r.resolve(stmt, tt.sql, tt.sql, Read)
+			if err != nil {
+				t.Fatal(err)
+			}
+			var got []string
+			for _, unit := range units {
+				got = append(got, unit.Sql)
+			}
+			if !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("resolve() sqls = %q, want %q", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestColocated(t *testing.T) {
+	ds0, ds1 := DbPolicyResult{Name: "ds_0"}, DbPolicyResult{Name: "ds_1"}
+	both := DbPolicyResult{Name: "ds_0", Scatter: []DbPolicyResult{ds0, ds1}}
+	tests := []struct {
+		name     string
+		dbResult DbPolicyResult
+		targets  []DbPolicyResult
+		wantErr  string
+	}{
+		{name: "same data node", dbResult: ds1, targets: []DbPolicyResult{ds1}},
+		{name: "different data node", dbResult: ds1, targets: []DbPolicyResult{ds0}, wantErr: "targets data node ds_1, different from ds_0"},
+		{name: "single data node while main table scatters", dbResult: ds1, targets: []DbPolicyResult{ds0, ds1}, wantErr: "targets data node ds_1, different from ds_0"},
+		{name: "scatter with main table", dbResult: both, targets: []DbPolicyResult{ds0, ds1}},
+		{name: "scatter while main table routed", dbResult: both, targets: []DbPolicyResult{ds1}, wantErr: "scatters to 2 data nodes, more than 1"},
+		{name: "scatter without main data node", dbResult: DbPolicyResult{Name: "ds_1", Scatter: []DbPolicyResult{ds1, {Name: "ds_2"}}}, targets: []DbPolicyResult{ds0}, wantErr: "scatters to data nodes without ds_0"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			err := colocated(tt.dbResult, tt.targets)
+			if (err == nil) != (tt.wantErr == "") || (err != nil && err.Error() != tt.wantErr) {
+				t.Errorf("colocated() error = %v, want %q", err, tt.wantErr)
+			}
+		})
+	}
+}
+
+// testReferenceRoutes order 按 user_id、user 按 id 分库分表，account 按 user_id 只分库
+func testReferenceRoutes() *DBRoute {
+	masters := map[ShardingName][]gorm.ConnPool{"ds_0": {&testConnPool{name: "ds_0"}}, "ds_1": {&testConnPool{name: "ds_1"}}}
+	rules := map[string]DataShardingRuleModel{
+		"order": {
+			Table:                      "order",
+			DatabaseShardingParameter:  "user_id",
+			DatabaseShardingExpression: "ds_${user_id % 2}",
+			TableShardingParameter:     "user_id",
+			TableShardingExpression:    "order_${user_id % 2}",
+			ActualTables:               []string{"order_0", "order_1"},
+		},
+		"user": {
+			Table:                      "user",
+			DatabaseShardingParameter:  "id",
+			DatabaseShardingExpression: "ds_${id % 2}",
+			TableShardingParameter:     "id",
+			TableShardingExpression:    "user_${id % 2}",
+			ActualTables:               []string{"user_0", "user_1"},
+		},
+		"account": {
+			Table:                      "account",
+			DatabaseShardingParameter:  "user_id",
+			DatabaseShardingExpression: "ds_${user_id % 2}",
+		},
+	}
+	dr := &DBRoute{routes: map[string]*route{}}
+	sharded := &route{
+		masters:  masters,
+		dbPolicy: &DbShardingRoutePolicy{DataShardingRuleModelMap: rules},
+		tbPolicy: &TbShardingRoutePolicy{DataShardingRuleModelMap: rules},
+		dbRoute:  dr,
+	}
+	dr.routes["order"], dr.routes["user"] = sharded, sharded
+	dr.routes["account"] = &route{
+		masters:  masters,
+		dbPolicy: &DbShardingRoutePolicy{DataShardingRuleModelMap: rules},
+		tbPolicy: TbDefaultPolicy{},
+		dbRoute:  dr,
+	}
+	return dr
+}
+
+// TestResolveReferences 子查询中的表按自身路由改写，须与主表位于相同数据源
+func TestResolveReferences(t *testing.T) {
+	tests := []struct {
+		name    string
+		sql     string
+		want    []string
+		wantErr bool
+	}{
+		{
+			name: "subquery routed to same data node",
+			sql:  "SELECT * FROM `order` WHERE user_id = 3 AND user_id IN (SELECT id FROM `user` WHERE id = 3)",
+			want: []string{"ds_1: select * from order_1 where user_id = 3 and user_id in (select id from user_1 where id = 3)"},
+		},
+		{
+			name:    "subquery routed to other data node",
+			sql:     "SELECT * FROM `order` WHERE user_id = 2 AND user_id IN (SELECT id FROM `user` WHERE id = 3)",
+			wantErr: true,
+		},
+		{
+			name:    "subquery table scatters",
+			sql:     "SELECT * FROM `order` WHERE user_id = 3 AND user_id IN (SELECT id FROM `user`)",
+			wantErr: true,
+		},
+		{
+			name: "subquery scatters with main table",
+			sql:  "SELECT * FROM `order` WHERE amount IN (SELECT amount FROM account)",
+			want: []string{
+				"ds_0: select * from order_0 where amount in (select amount from account)",
+				"ds_0: select * from order_1 where amount in (select amount from account)",
+				"ds_1: select * from order_0 where amount in (select amount from account)",
+				"ds_1: select * from order_1 where amount in (select amount from account)",
+			},
+		},
+		{
+			name:    "subquery scatters while main table routed",
+			sql:     "SELECT * FROM `order` WHERE user_id = 3 AND amount IN (SELECT amount FROM account)",
+			wantErr: true,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			r := testReferenceRoutes().routes["order"]
+			stmt := &gorm.Statement{DB: &gorm.DB{Config: &gorm.Config{}}, Context: context.Background(), Table: "order"}
+			stmt.Logger = logger.Discard
+			units, _, err := r.resolve(stmt, tt.sql, tt.sql, Read)
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("resolve() error = %v, wantErr %v", err, tt.wantErr)
+			}
+			var got []string
+			for _, unit := range units {
+				got = append(got, string(unit.Name)+": "+unit.Sql)
+			}
+			if !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("resolve() = %q, want %q", got, tt.want)
+			}
+		})
+	}
+}
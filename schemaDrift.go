@@ -0,0 +1,308 @@
+package dbroute
+
+import (
+	"context"
+	"fmt"
+	"gorm.io/gorm"
+	"sort"
+	"strings"
+)
+
+// DriftKind 表结构差异类型
+type DriftKind string
+
+const (
+	DriftMissingTable     DriftKind = "missing_table"
+	DriftMissingColumn    DriftKind = "missing_column"
+	DriftExtraColumn      DriftKind = "extra_column"
+	DriftColumnDefinition DriftKind = "column_definition"
+	DriftMissingIndex     DriftKind = "missing_index"
+	DriftExtraIndex       DriftKind = "extra_index"
+	// DriftError 无法读取表结构
+	DriftError DriftKind = "error"
+)
+
+// SchemaNode 数据节点，某个连接池上的一张物理表
+type SchemaNode struct {
+	Name ShardingName
+	// Role Master 或 Slave
+	Role string
+	// Index 同一数据源的第几个连接池
+	Index int
+	Table string
+
+	connPool gorm.ConnPool
+}
+
+func (n SchemaNode) String() string {
+	return fmt.Sprintf("%s/%s#%d/%s", n.Name, n.Role, n.Index, n.Table)
+}
+
+// SchemaDrift 数据节点与基准的一处差异
+type SchemaDrift struct {
+	Node SchemaNode
+	Kind DriftKind
+	// Object 列名或索引名
+	Object   string
+	Expected string
+	Actual   string
+}
+
+func (d SchemaDrift) String() string {
+	text := fmt.Sprintf("%s %s %s", d.Node, d.Kind, d.Object)
+	if d.Expected != "" {
+		text += fmt.Sprintf(" expected %q", d.Expected)
+	}
+	if d.Actual != "" {
+		text += fmt.Sprintf(" actual %q", d.Actual)
+	}
+	return text
+}
+
+// SchemaReport 逻辑表的结构差异报告
+type SchemaReport struct {
+	Table string
+	// Baseline 比较基准，模型或第一个存在该表的数据节点
+	Baseline string
+	Nodes    []SchemaNode
+	Drifts   []SchemaDrift
+}
+
+func (r *SchemaReport) String() string {
+	var builder strings.Builder
+	fmt.Fprintf(&builder, "%s: %d nodes, baseline %s, %d drifts\n", r.Table, len(r.Nodes), r.Baseline, len(r.Drifts))
+	for _, drift := range r.Drifts {
+		builder.WriteString("  ")
+		builder.WriteString(drift.String())
+		builder.WriteString("\n")
+	}
+	return builder.String()
+}
+
+// tableSchema 用于比较的表结构，列定义为空时只比较列是否存在，索引按列及唯一性比较而不区分名称
+type tableSchema struct {
+	columns     map[string]string
+	columnOrder []string
+	// 索引定义 -> 索引名，为 nil 时不比较索引
+	indexes    map[string]string
+	indexOrder []string
+}
+
+func (s *tableSchema) addColumn(name string, definition string) {
+	if s.columns == nil {
+		s.columns = map[string]string{}
+	}
+	s.columns[name] = definition
+	s.columnOrder = append(s.columnOrder, name)
+}
+
+func (s *tableSchema) addIndex(name string, columns []string, unique bool) {
+	if s.indexes == nil {
+		s.indexes = map[string]string{}
+	}
+	definition := "(" + strings.Join(columns, ",") + ")"
+	if unique {
+		definition = "unique" + definition
+	}
+	if _, ok := s.indexes[definition]; !ok {
+		s.indexes[definition] = name
+		s.indexOrder = append(s.indexOrder, definition)
+	}
+}
+
+// CheckSchema
+//
+//	@Description: 读取逻辑表在全部连接池（主库及从库）全部物理表上的列与索引，以第一个存在该表的数据节点为基准比较差异
+//	@param table	逻辑表
+//	@return *SchemaReport
+//	@return error
+func (dr *DBRoute) CheckSchema(table string) (*SchemaReport, error) {
+	nodes, err := dr.schemaNodes(table)
+	if err != nil {
+		return nil, err
+	}
+	ctx := context.WithValue(dr.DB.Statement.Context, skipRouteKey, true)
+	report := &SchemaReport{Table: table, Nodes: nodes}
+	schemas := make([]*tableSchema, len(nodes))
+	errs := make([]error, len(nodes))
+	var baseline *tableSchema
+	for i, node := range nodes {
+		if schemas[i], errs[i] = dr.readSchema(ctx, node); errs[i] == nil && schemas[i] != nil && baseline == nil {
+			baseline = schemas[i]
+			report.Baseline = node.String()
+		}
+	}
+	if baseline == nil {
+		return nil, fmt.Errorf("table %s does not exist on any node", table)
+	}
+	for i, node := range nodes {
+		report.Drifts = append(report.Drifts, compareNode(node, baseline, schemas[i], errs[i])...)
+	}
+	return report, nil
+}
+
+// CheckSchemaWithModel
+//
+//	@Description: 以 gorm 模型为基准比较逻辑表在全部数据节点上的差异，只比较列及索引是否存在，不比较列类型
+//	@param model
+//	@return *SchemaReport
+//	@return error
+func (dr *DBRoute) CheckSchemaWithModel(model interface{}) (*SchemaReport, error) {
+	stmt := &gorm.Statement{DB: dr.DB}
+	if err := stmt.Parse(model); err != nil {
+		return nil, err
+	}
+	nodes, err := dr.schemaNodes(stmt.Table)
+	if err != nil {
+		return nil, err
+	}
+	baseline := &tableSchema{indexes: map[string]string{}}
+	for _, field := range stmt.Schema.Fields {
+		if field.DBName == "" || field.IgnoreMigration {
+			continue
+		}
+		baseline.addColumn(field.DBName, "")
+		if field.Unique {
+			baseline.addIndex(field.DBName, []string{field.DBName}, true)
+		}
+	}
+	for _, index := range stmt.Schema.ParseIndexes() {
+		columns := make([]string, len(index.Fields))
+		for i, option := range index.Fields {
+			columns[i] = option.DBName
+		}
+		baseline.addIndex(index.Name, columns, index.Class == "UNIQUE")
+	}
+	ctx := context.WithValue(dr.DB.Statement.Context, skipRouteKey, true)
+	report := &SchemaReport{Table: stmt.Table, Baseline: "model " + stmt.Schema.Name, Nodes: nodes}
+	for _, node := range nodes {
+		schema, err := dr.readSchema(ctx, node)
+		report.Drifts = append(report.Drifts, compareNode(node, baseline, schema, err)...)
+	}
+	return report, nil
+}
+
+// CheckSchemas 按表名顺序检查全部已注册的逻辑表
+func (dr *DBRoute) CheckSchemas() ([]*SchemaReport, error) {
+	tables := make([]string, 0, len(dr.routes))
+	for table := range dr.routes {
+		tables = append(tables, table)
+	}
+	sort.Strings(tables)
+	reports := make([]*SchemaReport, 0, len(tables))
+	for _, table := range tables {
+		report, err := dr.CheckSchema(table)
+		if err != nil {
+			return reports, err
+		}
+		reports = append(reports, report)
+	}
+	return reports, nil
+}
+
+// schemaNodes 逻辑表的全部数据节点：主库、从库的每个连接池 × 每张物理表
+func (dr *DBRoute) schemaNodes(table string) ([]SchemaNode, error) {
+	r, ok := dr.routes[table]
+	if !ok {
+		r = dr.global
+	}
+	if r == nil {
+		return nil, fmt.Errorf("table %s is not registered", table)
+	}
+	var nodes []SchemaNode
+	for _, group := range []struct {
+		role  string
+		pools map[ShardingName][]gorm.ConnPool
+	}{{Master, r.masters}, {Slave, r.slaves}} {
+		for _, name := range shardingNames(group.pools) {
+			for i, connPool := range group.pools[name] {
+				for _, actualTable := range r.actualTables(table) {
+					nodes = append(nodes, SchemaNode{Name: name, Role: group.role, Index: i, Table: actualTable, connPool: connPool})
+				}
+			}
+		}
+	}
+	return nodes, nil
+}
+
+// readSchema 读取数据节点的表结构，表不存在时返回 nil，方言不支持读取索引时不比较索引
+func (dr *DBRoute) readSchema(ctx context.Context, node SchemaNode) (*tableSchema, error) {
+	migrator := dr.session(ctx, node.connPool).Migrator()
+	if !migrator.HasTable(node.Table) {
+		return nil, nil
+	}
+	columnTypes, err := migrator.ColumnTypes(node.Table)
+	if err != nil {
+		return nil, err
+	}
+	schema := &tableSchema{}
+	for _, columnType := range columnTypes {
+		definition, ok := columnType.ColumnType()
+		if !ok {
+			definition = columnType.DatabaseTypeName()
+		}
+		definition = strings.ToLower(definition)
+		if nullable, ok := columnType.Nullable(); ok && !nullable {
+			definition += " not null"
+		}
+		if primaryKey, ok := columnType.PrimaryKey(); ok && primaryKey {
+			definition += " primary key"
+		}
+		schema.addColumn(columnType.Name(), definition)
+	}
+	if indexes, err := migrator.GetIndexes(node.Table); err == nil {
+		schema.indexes = map[string]string{}
+		for _, index := range indexes {
+			// 主键由列定义比较，各方言对主键索引的命名与返回方式不同
+			if primaryKey, _ := index.PrimaryKey(); primaryKey {
+				continue
+			}
+			unique, _ := index.Unique()
+			schema.addIndex(index.Name(), index.Columns(), unique)
+		}
+	}
+	return schema, nil
+}
+
+// compareNode 读取失败的数据节点只报告错误
+func compareNode(node SchemaNode, baseline *tableSchema, actual *tableSchema, err error) []SchemaDrift {
+	if err != nil {
+		return []SchemaDrift{{Node: node, Kind: DriftError, Actual: err.Error()}}
+	}
+	return compareSchema(node, baseline, actual)
+}
+
+// compareSchema 比较数据节点与基准的表结构，actual 为 nil 表示表不存在
+func compareSchema(node SchemaNode, baseline *tableSchema, actual *tableSchema) (drifts []SchemaDrift) {
+	if actual == nil {
+		return []SchemaDrift{{Node: node, Kind: DriftMissingTable, Object: node.Table}}
+	}
+	for _, column := range baseline.columnOrder {
+		expected := baseline.columns[column]
+		definition, ok := actual.columns[column]
+		if !ok {
+			drifts = append(drifts, SchemaDrift{Node: node, Kind: DriftMissingColumn, Object: column, Expected: expected})
+		} else if expected != "" && definition != expected {
+			drifts = append(drifts, SchemaDrift{Node: node, Kind: DriftColumnDefinition, Object: column, Expected: expected, Actual: definition})
+		}
+	}
+	for _, column := range actual.columnOrder {
+		if _, ok := baseline.columns[column]; !ok {
+			drifts = append(drifts, SchemaDrift{Node: node, Kind: DriftExtraColumn, Object: column, Actual: actual.columns[column]})
+		}
+	}
+	if baseline.indexes == nil || actual.indexes == nil {
+		return drifts
+	}
+	for _, definition := range baseline.indexOrder {
+		if _, ok := actual.indexes[definition]; !ok {
+			drifts = append(drifts, SchemaDrift{Node: node, Kind: DriftMissingIndex, Object: baseline.indexes[definition], Expected: definition})
+		}
+	}
+	for _, definition := range actual.indexOrder {
+		if _, ok := baseline.indexes[definition]; !ok {
+			drifts = append(drifts, SchemaDrift{Node: node, Kind: DriftExtraIndex, Object: actual.indexes[definition], Actual: definition})
+		}
+	}
+	return drifts
+}
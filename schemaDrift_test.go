@@ -0,0 +1,97 @@
+package dbroute
+
+import (
+	"errors"
+	"reflect"
+	"testing"
+)
+
+// testTableSchema 按 名称、定义 成对的列构建表结构
+func testTableSchema(columns ...string) *tableSchema {
+	s := &tableSchema{}
+	for i := 0; i+1 < len(columns); i += 2 {
+		s.addColumn(columns[i], columns[i+1])
+	}
+	return s
+}
+
+func TestCompareSchema(t *testing.T) {
+	node := SchemaNode{Name: "ds_1", Role: Master, Table: "order_1"}
+	withIndexes := func(s *tableSchema, indexes ...func(*tableSchema)) *tableSchema {
+		s.indexes = map[string]string{}
+		for _, index := range indexes {
+			index(s)
+		}
+		return s
+	}
+	index := func(name string, unique bool, columns ...string) func(*tableSchema) {
+		return func(s *tableSchema) { s.addIndex(name, columns, unique) }
+	}
+	tests := []struct {
+		name     string
+		baseline *tableSchema
+		actual   *tableSchema
+		want     []SchemaDrift
+	}{
+		{
+			name:     "identical",
+			baseline: testTableSchema("id", "bigint", "status", "varchar(16)"),
+			actual:   testTableSchema("id", "bigint", "status", "varchar(16)"),
+		},
+		{
+			name:     "missing table",
+			baseline: testTableSchema("id", "bigint"),
+			want:     []SchemaDrift{{Node: node, Kind: DriftMissingTable, Object: "order_1"}},
+		},
+		{
+			name:     "columns",
+			baseline: testTableSchema("id", "bigint", "status", "varchar(16)", "amount", "decimal(10,2)"),
+			actual:   testTableSchema("id", "bigint", "status", "varchar(32)", "remark", "text"),
+			want: []SchemaDrift{
+				{Node: node, Kind: DriftColumnDefinition, Object: "status", Expected: "varchar(16)", Actual: "varchar(32)"},
+				{Node: node, Kind: DriftMissingColumn, Object: "amount", Expected: "decimal(10,2)"},
+				{Node: node, Kind: DriftExtraColumn, Object: "remark", Actual: "text"},
+			},
+		},
+		{
+			name:     "model baseline without definitions",
+			baseline: testTableSchema("id", "", "status", ""),
+			actual:   testTableSchema("id", "bigint", "status", "varchar(16)"),
+		},
+		{
+			name:     "indexes compared by columns",
+			baseline: withIndexes(testTableSchema("id", "bigint"), index("idx_order_0_status", false, "status"), index("uk_user", true, "user_id"), index("idx_created", false, "created_at")),
+			actual:   withIndexes(testTableSchema("id", "bigint"), index("idx_order_1_status", false, "status"), index("idx_user", false, "user_id"), index("idx_tenant", false, "tenant_id", "user_id")),
+			want: []SchemaDrift{
+				{Node: node, Kind: DriftMissingIndex, Object: "uk_user", Expected: "unique(user_id)"},
+				{Node: node, Kind: DriftMissingIndex, Object: "idx_created", Expected: "(created_at)"},
+				{Node: node, Kind: DriftExtraIndex, Object: "idx_user", Actual: "(user_id)"},
+				{Node: node, Kind: DriftExtraIndex, Object: "idx_tenant", Actual: "(tenant_id,user_id)"},
+			},
+		},
+		{
+			name:     "indexes not read",
+			baseline: withIndexes(testTableSchema("id", "bigint"), index("idx_status", false, "status")),
+			actual:   testTableSchema("id", "bigint"),
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := compareSchema(node, tt.baseline, tt.actual); !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("compareSchema() = %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestCompareNodeError(t *testing.T) {
+	node := SchemaNode{Name: "ds_0", Role: Slave, Index: 1, Table: "order_0"}
+	got := compareNode(node, testTableSchema("id", "bigint"), nil, errors.New("connection refused"))
+	want := []SchemaDrift{{Node: node, Kind: DriftError, Actual: "connection refused"}}
+	if !reflect.DeepEqual(got, want) {
+		t.Fatalf("compareNode() = %v, want %v", got, want)
+	}
+	if text := got[0].String(); text != `ds_0/slave#1/order_0 error  actual "connection refused"` {
+		t.Errorf("String() = %s", text)
+	}
+}
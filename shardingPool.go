@@ -0,0 +1,227 @@
+package dbroute
+
+import (
+	"context"
+	"database/sql"
+	"database/sql/driver"
+	"errors"
+	"fmt"
+	"gorm.io/gorm"
+	"strings"
+	"sync"
+)
+
+// shardingConnPool 分散执行连接池，将语句分发到多个数据节点并发执行并归并结果
+type shardingConnPool struct {
+	units []routeUnit
+	// 查询结果归并计划，为空时按路由顺序拼接
+	plan *mergePlan
+	// 各节点的执行结果，用于回填拆分插入的自增主键
+	results []sql.Result
+	// 写操作在各节点的事务中执行，任一节点执行失败则全部回滚；全部执行成功后逐个提交，
+	// 提交为尽力而为（非 XA），提交失败时已提交的节点无法回滚
+	atomic bool
+	// 各节点共用同一事务连接，依次执行
+	sequential bool
+}
+
+// shardingResult 多个数据节点的执行结果汇总
+type shardingResult struct {
+	rowsAffected int64
+}
+
+// LastInsertId 各节点自增主键不连续，由 gorm:db_route:insert_id 回调按节点回填
+func (r shardingResult) LastInsertId() (int64, error) {
+	return 0, nil
+}
+
+func (r shardingResult) RowsAffected() (int64, error) {
+	return r.rowsAffected, nil
+}
+
+func (p *shardingConnPool) PrepareContext(_ context.Context, _ string) (*sql.Stmt, error) {
+	return nil, errors.New("prepare is not supported across multiple data nodes")
+}
+
+func (p *shardingConnPool) ExecContext(ctx context.Context, _ string, args ...interface{}) (sql.Result, error) {
+	p.results = make([]sql.Result, len(p.units))
+	err := p.each(ctx, func(i int, unit routeUnit, connPool gorm.ConnPool) (err error) {
+		unitArgs := args
+		if unit.Vars != nil {
+			unitArgs = unit.Vars
+		}
+		p.results[i], err = connPool.ExecContext(ctx, unit.Sql, unitArgs...)
+		return err
+	})
+	if err != nil {
+		return nil, err
+	}
+	var result shardingResult
+	for _, r := range p.results {
+		rowsAffected, err := r.RowsAffected()
+		if err != nil {
+			return nil, err
+		}
+		result.rowsAffected += rowsAffected
+	}
+	return result, nil
+}
+
+func (p *shardingConnPool) QueryContext(ctx context.Context, _ string, args ...interface{}) (*sql.Rows, error) {
+	rs, err := p.query(ctx, args)
+	if err != nil {
+		return nil, err
+	}
+	return resultSetDB().QueryContext(ctx, "", rs)
+}
+
+func (p *shardingConnPool) QueryRowContext(ctx context.Context, _ string, args ...interface{}) *sql.Row {
+	rs, err := p.query(ctx, args)
+	if err != nil {
+		// 由内存驱动返回错误，使 Row.Scan 得到该错误
+		return resultSetDB().QueryRowContext(ctx, "", err)
+	}
+	return resultSetDB().QueryRowContext(ctx, "", rs)
+}
+
+// query 并发查询全部数据节点并归并结果
+func (p *shardingConnPool) query(ctx context.Context, args []interface{}) (*resultSet, error) {
+	shardArgs := args
+	if p.plan != nil {
+		shardArgs = p.plan.shardArgs(args)
+	}
+	sets := make([]*resultSet, len(p.units))
+	err := p.each(ctx, func(i int, unit routeUnit, connPool gorm.ConnPool) error {
+		unitArgs := shardArgs
+		if unit.Vars != nil {
+			unitArgs = unit.Vars
+		}
+		rows, err := connPool.QueryContext(ctx, unit.Sql, unitArgs...)
+		if err != nil {
+			return err
+		}
+		defer rows.Close()
+		sets[i], err = readResultSet(rows)
+		return err
+	})
+	if err != nil {
+		return nil, err
+	}
+	if p.plan != nil {
+		return p.plan.merge(sets, args)
+	}
+	return p.insertResultSet(sets), nil
+}
+
+// insertResultSet 拆分插入的 RETURNING 结果按原始行的顺序排列，使 gorm 按顺序回填主键，
+// 非拆分插入或各节点返回行数与拆分行数不一致时按路由顺序拼接
+func (p *shardingConnPool) insertResultSet(sets []*resultSet) *resultSet {
+	rs := concatResultSets(sets)
+	var total int
+	for i, unit := range p.units {
+		if len(unit.Rows) == 0 || sets[i] == nil || len(sets[i].rows) != len(unit.Rows) {
+			return rs
+		}
+		total += len(unit.Rows)
+	}
+	rows := make([][]driver.Value, total)
+	for i, unit := range p.units {
+		for j, row := range unit.Rows {
+			if row >= total {
+				return rs
+			}
+			rows[row] = sets[i].rows[j]
+		}
+	}
+	rs.rows = rows
+	return rs
+}
+
+// beginTx 在连接池上开启事务
+func beginTx(ctx context.Context, connPool gorm.ConnPool, opt *sql.TxOptions) (gorm.ConnPool, error) {
+	switch beginner := connPool.(type) {
+	case gorm.TxBeginner:
+		return beginner.BeginTx(ctx, opt)
+	case gorm.ConnPoolBeginner:
+		tx, err := beginner.BeginTx(ctx, opt)
+		if err != nil {
+			return nil, err
+		}
+		if _, ok := tx.(gorm.TxCommitter); !ok {
+			return nil, gorm.ErrInvalidTransaction
+		}
+		return tx, nil
+	}
+	return nil, gorm.ErrInvalidTransaction
+}
+
+// finishTxs
+//
+//	@Description: 执行出错时回滚全部事务，否则逐个提交。各节点事务相互独立，提交不是原子的：
+//	某节点提交失败时回滚其余未提交的事务，已提交的节点无法回滚，错误中列出这些节点以便补偿
+//	@receiver p
+//	@param txs	各节点的事务，未开启事务的节点为 nil
+//	@param err	执行阶段的错误
+//	@return error
+func (p *shardingConnPool) finishTxs(txs []gorm.TxCommitter, err error) error {
+	var committed []string
+	for i, tx := range txs {
+		if tx == nil {
+			continue
+		}
+		if err != nil {
+			_ = tx.Rollback()
+			continue
+		}
+		if err = tx.Commit(); err != nil {
+			err = fmt.Errorf("commit data node %s: %w", p.units[i].Name, err)
+			if len(committed) > 0 {
+				err = fmt.Errorf("%w, already committed data nodes: %s", err, strings.Join(committed, ", "))
+			}
+			continue
+		}
+		committed = append(committed, string(p.units[i].Name))
+	}
+	return err
+}
+
+// each 并发执行，返回第一个失败节点的错误，atomic 时各节点在事务中执行，sequential 时依次执行
+func (p *shardingConnPool) each(ctx context.Context, fc func(int, routeUnit, gorm.ConnPool) error) error {
+	if p.sequential {
+		for i, unit := range p.units {
+			if err := fc(i, unit, unit.ConnPool); err != nil {
+				return fmt.Errorf("data node %s.%s: %w", unit.Name, unit.Table, err)
+			}
+		}
+		return nil
+	}
+	var wg sync.WaitGroup
+	errs := make([]error, len(p.units))
+	txs := make([]gorm.TxCommitter, len(p.units))
+	for i, unit := range p.units {
+		wg.Add(1)
+		go func(i int, unit routeUnit) {
+			defer wg.Done()
+			connPool := unit.ConnPool
+			if p.atomic {
+				if connPool, errs[i] = beginTx(ctx, connPool, nil); errs[i] != nil {
+					return
+				}
+				txs[i] = connPool.(gorm.TxCommitter)
+			}
+			errs[i] = fc(i, unit, connPool)
+		}(i, unit)
+	}
+	wg.Wait()
+	var err error
+	for i := range errs {
+		if errs[i] != nil {
+			err = fmt.Errorf("data node %s.%s: %w", p.units[i].Name, p.units[i].Table, errs[i])
+			break
+		}
+	}
+	if p.atomic {
+		err = p.finishTxs(txs, err)
+	}
+	return err
+}
@@ -0,0 +1,130 @@
+package dbroute
+
+import (
+	"database/sql/driver"
+	"errors"
+	"gorm.io/gorm"
+	"reflect"
+	"testing"
+)
+
+func TestInsertResultSet(t *testing.T) {
+	tests := []struct {
+		name  string
+		units []routeUnit
+		sets  []*resultSet
+		want  []int64
+	}{
+		{
+			name:  "returning rows restored to insert order",
+			units: []routeUnit{{Rows: []int{0, 2}}, {Rows: []int{1}}},
+			sets:  []*resultSet{testResultSet(10, 11), testResultSet(20)},
+			want:  []int64{10, 20, 11},
+		},
+		{
+			name:  "row count mismatch concatenated",
+			units: []routeUnit{{Rows: []int{0, 2}}, {Rows: []int{1}}},
+			sets:  []*resultSet{testResultSet(10), testResultSet(20)},
+			want:  []int64{10, 20},
+		},
+		{
+			name:  "scatter concatenated",
+			units: []routeUnit{{}, {}},
+			sets:  []*resultSet{testResultSet(1, 2), testResultSet(3)},
+			want:  []int64{1, 2, 3},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			rs := (&shardingConnPool{units: tt.units}).insertResultSet(tt.sets)
+			var got []int64
+			for _, row := range rs.rows {
+				got = append(got, row[0].(int64))
+			}
+			if !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("insertResultSet() = %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
+
+func testResultSet(ids ...int64) *resultSet {
+	rs := &resultSet{columns: []string{"id"}}
+	for _, id := range ids {
+		rs.rows = append(rs.rows, []driver.Value{id})
+	}
+	return rs
+}
+
+// testTx 记录提交、回滚的事务
+type testTx struct {
+	commitErr  error
+	committed  bool
+	rolledBack bool
+}
+
+func (tx *testTx) Commit() error {
+	if tx.commitErr != nil {
+		return tx.commitErr
+	}
+	tx.committed = true
+	return nil
+}
+
+func (tx *testTx) Rollback() error {
+	tx.rolledBack = true
+	return nil
+}
+
+func TestFinishTxs(t *testing.T) {
+	tests := []struct {
+		name         string
+		execErr      error
+		commitErrs   []error
+		wantErr      string
+		wantCommit   []bool
+		wantRollback []bool
+	}{
+		{
+			name:         "all committed",
+			commitErrs:   []error{nil, nil, nil},
+			wantCommit:   []bool{true, true, true},
+			wantRollback: []bool{false, false, false},
+		},
+		{
+			name:         "exec error rolls back all",
+			execErr:      errors.New("exec"),
+			commitErrs:   []error{nil, nil, nil},
+			wantErr:      "exec",
+			wantCommit:   []bool{false, false, false},
+			wantRollback: []bool{true, true, true},
+		},
+		{
+			name:         "commit error names committed nodes",
+			commitErrs:   []error{nil, errors.New("lost"), nil},
+			wantErr:      "commit data node ds_1: lost, already committed data nodes: ds_0",
+			wantCommit:   []bool{true, false, false},
+			wantRollback: []bool{false, false, true},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			p := &shardingConnPool{units: []routeUnit{{Name: "ds_0"}, {Name: "ds_1"}, {Name: "ds_2"}}}
+			txs := make([]gorm.TxCommitter, len(tt.commitErrs))
+			pending := make([]*testTx, len(tt.commitErrs))
+			for i, commitErr := range tt.commitErrs {
+				pending[i] = &testTx{commitErr: commitErr}
+				txs[i] = pending[i]
+			}
+			err := p.finishTxs(txs, tt.execErr)
+			if (err == nil) != (tt.wantErr == "") || (err != nil && err.Error() != tt.wantErr) {
+				t.Fatalf("finishTxs() error = %v, want %q", err, tt.wantErr)
+			}
+			for i, tx := range pending {
+				if tx.committed != tt.wantCommit[i] || tx.rolledBack != tt.wantRollback[i] {
+					t.Errorf("tx %d committed = %v, rolledBack = %v", i, tx.committed, tx.rolledBack)
+				}
+			}
+		})
+	}
+}
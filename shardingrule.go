@@ -1,5 +1,11 @@
 package dbroute
 
+import (
+	"fmt"
+	"sort"
+	"strconv"
+)
+
 // DataShardingRuleModel 数据分片规则
 type DataShardingRuleModel struct {
 	Table                        string `json:"table"`
@@ -8,7 +14,200 @@ type DataShardingRuleModel struct {
 	DatabaseShardingExpression   string `json:"database-sharding-expression"`
 	TableShardingParameter       string `json:"table-sharding-parameter"`
 	TableShardingExpression      string `json:"table-sharding-expression"`
-	Rules                        []Rule `json:"rules"`
+	// DatabaseShardingParameters、TableShardingParameters 多列分片键，如 tenant_id、account_id，设置后取代对应的单列分片键，全部列的取值传入分片表达式
+	DatabaseShardingParameters []string `json:"database-sharding-parameters"`
+	TableShardingParameters    []string `json:"table-sharding-parameters"`
+	// DatabaseShardingAlgorithm、TableShardingAlgorithm 内置或注册的分片算法，设置后取代对应的分片表达式
+	DatabaseShardingAlgorithm *ShardingAlgorithmConfig `json:"database-sharding-algorithm"`
+	TableShardingAlgorithm    *ShardingAlgorithmConfig `json:"table-sharding-algorithm"`
+	// GeneParameter 携带分片键基因的列，如 order_id 的低 GeneBits 位取自 user_id（见 NewGeneSnowflake），
+	// 单列分片键未命中时以该列取值的低位作为分片键的取值，分库、分表须为 MOD 算法且分片数整除 2^GeneBits，如 GeneBits 为 2 时分片数为 2 或 4
+	GeneParameter string `json:"gene-parameter"`
+	GeneBits      int    `json:"gene-bits"`
+	// ActualTables 全部物理表，未命中分表键时分散到这些表执行
+	ActualTables []string `json:"actual-tables"`
+	Rules        []Rule   `json:"rules"`
+}
+
+// databaseShardingParameters 分库键
+func (m DataShardingRuleModel) databaseShardingParameters() []string {
+	return shardingParameters(m.DatabaseShardingParameters, m.DatabaseShardingParameter)
+}
+
+// tableShardingParameters 分表键
+func (m DataShardingRuleModel) tableShardingParameters() []string {
+	return shardingParameters(m.TableShardingParameters, m.TableShardingParameter)
+}
+
+// shardingFunc 分片键取值到分片名的计算
+type shardingFunc func(values map[string]interface{}) (string, error)
+
+// databaseSharding
+//
+//	@Description: 分库键取值到数据源名的计算，表达式结果为序号、或算法未配置前缀且结果为整数时，对应按名称排序的数据源
+//	@param names	全部数据源
+//	@return shardingFunc
+func (m DataShardingRuleModel) databaseSharding(names []ShardingName) shardingFunc {
+	if m.DatabaseShardingAlgorithm != nil {
+		sharding := m.DatabaseShardingAlgorithm.sharding("")
+		if m.DatabaseShardingAlgorithm.Prefix != "" {
+			return sharding
+		}
+		return func(values map[string]interface{}) (string, error) {
+			suffix, err := sharding(values)
+			if err != nil {
+				return "", err
+			}
+			index, err := strconv.ParseInt(suffix, 10, 64)
+			if err != nil || index < 0 {
+				// 如 INTERVAL 的时间后缀，直接作为数据源名
+				return suffix, nil
+			}
+			name, err := expressionResult{index: index, isIndex: true}.databaseName(names)
+			return string(name), err
+		}
+	}
+	return func(values map[string]interface{}) (string, error) {
+		result, err := evaluateExpression(m.DatabaseShardingExpression, values)
+		if err != nil {
+			return "", err
+		}
+		name, err := result.databaseName(names)
+		return string(name), err
+	}
+}
+
+// tableSharding 分表键取值到物理表名的计算，表达式结果为序号时物理表名为 表名_序号
+func (m DataShardingRuleModel) tableSharding() shardingFunc {
+	if m.TableShardingAlgorithm != nil {
+		return m.TableShardingAlgorithm.sharding(m.Table + "_")
+	}
+	return func(values map[string]interface{}) (string, error) {
+		result, err := evaluateExpression(m.TableShardingExpression, values)
+		if err != nil {
+			return "", err
+		}
+		return result.tableName(m.Table), nil
+	}
+}
+
+// shardingConditions 分片键条件，单列分片键未命中时按基因列的取值推算分片键
+func (m DataShardingRuleModel) shardingConditions(sql string, parameters []string) (ShardingConditions, error) {
+	conds, err := GetSqlShardingConditions(sql, parameters...)
+	if err != nil {
+		return nil, err
+	}
+	if m.GeneParameter == "" || m.GeneBits <= 0 || len(parameters) != 1 || !conds[parameters[0]].All {
+		return conds, nil
+	}
+	gene, err := GetSqlShardingCondition(sql, m.GeneParameter)
+	if err != nil {
+		return nil, err
+	}
+	if gene.All || len(gene.Ranges) > 0 {
+		return conds, nil
+	}
+	var cond ShardingCondition
+	for _, value := range gene.Values {
+		id, err := toInt64(value)
+		if err != nil {
+			return conds, nil
+		}
+		cond.Values = appendValue(cond.Values, Gene(id, m.GeneBits))
+	}
+	return ShardingConditions{parameters[0]: cond}, nil
+}
+
+// validate 校验分库、分表的算法配置及表达式，表达式在此时预编译
+func (m DataShardingRuleModel) validate() error {
+	if m.DatabaseShardingAlgorithm != nil {
+		if err := validateAlgorithm(m.DatabaseShardingAlgorithm, m.databaseShardingParameters()); err != nil {
+			return fmt.Errorf("database sharding: %w", err)
+		}
+	} else if m.DatabaseShardingExpression != "" {
+		if err := validateExpression(m.DatabaseShardingExpression, m.databaseShardingParameters()); err != nil {
+			return fmt.Errorf("database sharding: %w", err)
+		}
+	}
+	if m.TableShardingAlgorithm != nil {
+		if err := validateAlgorithm(m.TableShardingAlgorithm, m.tableShardingParameters()); err != nil {
+			return fmt.Errorf("table sharding: %w", err)
+		}
+	} else if m.TableShardingExpression != "" {
+		if err := validateExpression(m.TableShardingExpression, m.tableShardingParameters()); err != nil {
+			return fmt.Errorf("table sharding: %w", err)
+		}
+	}
+	if m.GeneParameter != "" {
+		if err := m.validateGene(); err != nil {
+			return fmt.Errorf("gene sharding: %w", err)
+		}
+	}
+	return nil
+}
+
+// validateGene 基因分片要求分库、分表均为 MOD 算法，且分片数整除 2^GeneBits，保证分片结果只依赖分片键的低 GeneBits 位
+func (m DataShardingRuleModel) validateGene() error {
+	if m.GeneBits <= 0 || m.GeneBits >= snowflakeSequenceBits {
+		return fmt.Errorf("gene-bits %d out of range [1, %d]", m.GeneBits, snowflakeSequenceBits-1)
+	}
+	shardings := []struct {
+		parameters []string
+		algorithm  *ShardingAlgorithmConfig
+	}{
+		{parameters: m.databaseShardingParameters(), algorithm: m.DatabaseShardingAlgorithm},
+		{parameters: m.tableShardingParameters(), algorithm: m.TableShardingAlgorithm},
+	}
+	for _, sharding := range shardings {
+		if len(sharding.parameters) == 0 {
+			continue
+		}
+		if sharding.algorithm == nil || sharding.algorithm.Type != AlgorithmMod {
+			return fmt.Errorf("sharding of %v must use %s algorithm", sharding.parameters, AlgorithmMod)
+		}
+		if count := sharding.algorithm.Props.ShardingCount; (int64(1)<<m.GeneBits)%count != 0 {
+			return fmt.Errorf("sharding-count %d must divide 2^%d", count, m.GeneBits)
+		}
+	}
+	return nil
+}
+
+// validateAlgorithm 校验算法配置并缓存创建的算法，内置的单列算法不支持多列分片键
+func validateAlgorithm(config *ShardingAlgorithmConfig, parameters []string) error {
+	algorithm, err := NewShardingAlgorithm(*config)
+	if err != nil {
+		return err
+	}
+	if _, single := algorithm.(singleValueAlgorithm); single && len(parameters) > 1 {
+		return fmt.Errorf("sharding algorithm %s supports a single sharding column, got %v", config.Type, parameters)
+	}
+	config.algorithm = algorithm
+	return nil
+}
+
+// validateRules 按表名顺序校验分片规则
+func validateRules(rules map[string]DataShardingRuleModel) error {
+	tables := make([]string, 0, len(rules))
+	for table := range rules {
+		tables = append(tables, table)
+	}
+	sort.Strings(tables)
+	for _, table := range tables {
+		if err := rules[table].validate(); err != nil {
+			return fmt.Errorf("sharding rule of %s: %w", table, err)
+		}
+	}
+	return nil
+}
+
+func shardingParameters(parameters []string, parameter string) []string {
+	if len(parameters) > 0 {
+		return parameters
+	}
+	if parameter != "" {
+		return []string{parameter}
+	}
+	return nil
 }
 
 type Rule struct {
@@ -5,158 +5,259 @@ import (
 	"github.com/xwb1989/sqlparser"
 	"regexp"
 	"strconv"
+	"strings"
 )
 
-// GetSqlTableNameAndCommandType 从sql中取表名
-func GetSqlTableNameAndCommandType(sql string) (string, CommandType) {
+var placeholderRegexp = regexp.MustCompile(`:v\d+`)
+
+// GetSqlTableNameAndCommandType 从sql中取表名，解析失败时返回错误
+func GetSqlTableNameAndCommandType(sql string) (string, CommandType, error) {
 	stmt, err := sqlparser.Parse(sql)
 	if err != nil {
-		panic(fmt.Errorf("parse sql err: %w", err))
+		return "", "", fmt.Errorf("parse sql err: %w", err)
+	}
+	if tableName, commandType, ok := tableNameAndCommandType(stmt); ok {
+		return tableName, commandType, nil
 	}
+	return "", "", fmt.Errorf("table_name not found: %s", sql)
+}
+
+// tableNameAndCommandType 主表名，关联查询时为最左侧的表
+func tableNameAndCommandType(stmt sqlparser.Statement) (string, CommandType, bool) {
 	switch node := stmt.(type) {
 	case *sqlparser.Select:
-		if expr, ok := node.From[0].(*sqlparser.AliasedTableExpr); ok {
-			return expr.Expr.(sqlparser.TableName).Name.String(), SELECT
-		}
+		tableName, ok := firstTableName(node.From)
+		return tableName, SELECT, ok
 	case *sqlparser.Insert:
-		return node.Table.Name.String(), INSERT
+		return node.Table.Name.String(), INSERT, true
 	case *sqlparser.Update:
+		tableName, ok := firstTableName(node.TableExprs)
+		return tableName, UPDATE, ok
 	case *sqlparser.Delete:
-		if expr, ok := node.TableExprs[0].(*sqlparser.AliasedTableExpr); ok {
-			return expr.Expr.(sqlparser.TableName).Name.String(), UPDATE
+		tableName, ok := firstTableName(node.TableExprs)
+		return tableName, DELETE, ok
+	case *sqlparser.Union:
+		return tableNameAndCommandType(node.Left)
+	case *sqlparser.ParenSelect:
+		return tableNameAndCommandType(node.Select)
+	}
+	return "", "", false
+}
+
+func firstTableName(exprs sqlparser.TableExprs) (string, bool) {
+	if len(exprs) == 0 {
+		return "", false
+	}
+	switch e := exprs[0].(type) {
+	case *sqlparser.AliasedTableExpr:
+		if t, ok := e.Expr.(sqlparser.TableName); ok {
+			return t.Name.String(), true
 		}
+	case *sqlparser.ParenTableExpr:
+		return firstTableName(e.Exprs)
+	case *sqlparser.JoinTableExpr:
+		return firstTableName(sqlparser.TableExprs{e.LeftExpr})
 	}
-	panic(fmt.Errorf("table_name not found: %w", err))
+	return "", false
 }
 
-// GetSqlCommandType 从sql中取表名
-func GetSqlCommandType(sql string) CommandType {
+// GetSqlCommandType 从sql中取语句类型，解析失败时返回错误
+func GetSqlCommandType(sql string) (CommandType, error) {
 	stmt, err := sqlparser.Parse(sql)
 	if err != nil {
-		panic(fmt.Errorf("parse sql err: %w", err))
+		return "", fmt.Errorf("parse sql err: %w", err)
 	}
 	switch stmt.(type) {
 	case *sqlparser.Select:
-		return SELECT
+		return SELECT, nil
 	case *sqlparser.Insert:
-		return INSERT
+		return INSERT, nil
 	case *sqlparser.Update:
+		return UPDATE, nil
 	case *sqlparser.Delete:
-		return UPDATE
+		return DELETE, nil
+	}
+	return "", fmt.Errorf("commondType not found: %s", sql)
+}
+
+// GetSqlParameterValue 从sql中按key取值，解析失败时返回错误
+func GetSqlParameterValue(sql string, key string) (interface{}, error) {
+	stmt, err := sqlparser.Parse(sql)
+	if err != nil {
+		return nil, fmt.Errorf("parse sql err: %w", err)
 	}
-	panic(fmt.Errorf("commondType not found: %w", err))
+	return getSqlParameterValue(stmt, key), nil
 }
 
-// GetSqlParameterValue 从sql中按key取值
-func GetSqlParameterValue(sql string, key string) interface{} {
+// 分片键条件为单个取值时返回该值
+func getSqlParameterValue(stmt sqlparser.Statement, key string) interface{} {
+	cond := getSqlShardingCondition(stmt, key)
+	if !cond.All && len(cond.Values) == 1 && len(cond.Ranges) == 0 {
+		return cond.Values[0]
+	}
+	return nil
+}
+
+// ChangeSqlTableName 更新sql中的主表名，保留别名及关联的其他表，并同步以原表名限定的列，解析失败时返回错误
+func ChangeSqlTableName(sql string, newTableName string) (string, error) {
 	stmt, err := sqlparser.Parse(sql)
 	if err != nil {
-		panic(fmt.Errorf("parse sql err: %w", err))
+		return "", fmt.Errorf("parse sql err: %w", err)
 	}
-	return getSqlParameterValue(stmt, key)
+	tableName, _, ok := tableNameAndCommandType(stmt)
+	if !ok {
+		return "", fmt.Errorf("not support parser: %s", sql)
+	}
+	return changeTableNames(stmt, map[string]string{tableName: newTableName}), nil
 }
 
-// ChangeSqlTableName 更新sql中的表名
-func ChangeSqlTableName(sql string, newTableName string) string {
+// ChangeSqlTableNames 按映射更新sql中的表名，包括 JOIN 中的各表，保留别名，并同步以原表名限定的列，解析失败时返回错误
+func ChangeSqlTableNames(sql string, tableNames map[string]string) (string, error) {
 	stmt, err := sqlparser.Parse(sql)
 	if err != nil {
-		panic(fmt.Errorf("parse sql err: %w", err))
+		return "", fmt.Errorf("parse sql err: %w", err)
 	}
-	newSql := sql
+	return changeTableNames(stmt, tableNames), nil
+}
+
+func changeTableNames(stmt sqlparser.Statement, tableNames map[string]string) string {
 	switch node := stmt.(type) {
-	case *sqlparser.Select:
-		if expr, ok := node.From[0].(*sqlparser.AliasedTableExpr); ok {
-			t := expr.Expr.(sqlparser.TableName)
-			expr := sqlparser.AliasedTableExpr{
-				Expr: sqlparser.TableName{
-					// 表名
-					Name: sqlparser.NewTableIdent(newTableName),
-					// 库名
-					Qualifier: sqlparser.NewTableIdent(t.Qualifier.String()),
-				},
-				Partitions: nil,
-				As:         sqlparser.NewTableIdent(""),
-				Hints:      nil,
-			}
-			node.From = sqlparser.TableExprs{&expr}
-			newSql = sqlparser.String(node)
-		} else {
-			panic(fmt.Errorf("not support parser: %v", node.From[0]))
-		}
 	case *sqlparser.Insert:
-		name := sqlparser.NewTableIdent(newTableName)
-		node.Table.Name = name
-		newSql = sqlparser.String(node)
-	case *sqlparser.Update:
+		if name, ok := tableNames[node.Table.Name.String()]; ok {
+			node.Table.Name = sqlparser.NewTableIdent(name)
+		}
 	case *sqlparser.Delete:
-		if expr, ok := node.TableExprs[0].(*sqlparser.AliasedTableExpr); ok {
-			t := expr.Expr.(sqlparser.TableName)
-			expr := sqlparser.AliasedTableExpr{
-				Expr: sqlparser.TableName{
-					// 表名
-					Name: sqlparser.NewTableIdent(newTableName),
-					// 库名
-					Qualifier: sqlparser.NewTableIdent(t.Qualifier.String()),
-				},
-				Partitions: nil,
-				As:         sqlparser.NewTableIdent(""),
-				Hints:      nil,
+		for i, target := range node.Targets {
+			if name, ok := tableNames[target.Name.String()]; ok {
+				node.Targets[i].Name = sqlparser.NewTableIdent(name)
 			}
-			node.TableExprs = sqlparser.TableExprs{&expr}
-			newSql = sqlparser.String(node)
-		} else {
-			panic(fmt.Errorf("not support parser: %v", node.TableExprs[0]))
 		}
 	}
-	// sqlparser生成的sql中，原sql带有?会被替换成:v+数字，需对其做替换
-	pattern := `:v\d+`
-	reg := regexp.MustCompile(pattern)
-	newSql = reg.ReplaceAllString(newSql, "?")
-	return newSql
+	renameTables(stmt, tableNames)
+	renameQualifiers(stmt, tableNames)
+	return restorePlaceholder(sqlparser.String(stmt))
 }
 
-// 通过解析器遍历按key取值
-func getSqlParameterValue(parser any, key string) interface{} {
-	switch node := parser.(type) {
-	case *sqlparser.Select:
-		if node.Where != nil {
-			return getSqlParameterValue(node.Where.Expr, key)
+// renameTables 按映射替换全部表引用，包括 JOIN、子查询、派生表、UNION 各分支及 INSERT ... SELECT，保留库名与别名
+func renameTables(node sqlparser.SQLNode, tableNames map[string]string) {
+	_ = sqlparser.Walk(func(node sqlparser.SQLNode) (bool, error) {
+		if e, ok := node.(*sqlparser.AliasedTableExpr); ok {
+			if t, ok := e.Expr.(sqlparser.TableName); ok {
+				if name, ok := tableNames[t.Name.String()]; ok {
+					t.Name = sqlparser.NewTableIdent(name)
+					e.Expr = t
+				}
+			}
 		}
-	case *sqlparser.Insert:
-	case *sqlparser.ComparisonExpr:
-		if name, ok := node.Left.(*sqlparser.ColName); ok {
-			if name.Name.CompliantName() == key {
-				println("name: ", name.Name.CompliantName())
-				right := node.Right.(*sqlparser.SQLVal)
-				switch right.Type {
-				case sqlparser.StrVal:
-					return string(right.Val)
-				case sqlparser.IntVal:
-					strVal := string(right.Val)
-					value, err := strconv.ParseInt(strVal, 10, 64)
-					if err != nil {
-						panic(fmt.Errorf("parse int err: %w", err))
-					}
-					return value
-				case sqlparser.FloatVal:
-					strVal := string(right.Val)
-					value, err := strconv.ParseFloat(strVal, 64)
-					if err != nil {
-						panic(fmt.Errorf("parse float err: %w", err))
+		return true, nil
+	}, node)
+}
+
+// tableSelects 语句中各表所在的查询，同一表出现多次时取最外层，用于按该查询的条件解析分片键
+func tableSelects(node sqlparser.SQLNode) map[string]*sqlparser.Select {
+	selects := make(map[string]*sqlparser.Select)
+	_ = sqlparser.Walk(func(node sqlparser.SQLNode) (bool, error) {
+		if sel, ok := node.(*sqlparser.Select); ok {
+			_ = sqlparser.Walk(func(node sqlparser.SQLNode) (bool, error) {
+				switch e := node.(type) {
+				case *sqlparser.AliasedTableExpr:
+					if t, ok := e.Expr.(sqlparser.TableName); ok {
+						if _, ok := selects[t.Name.String()]; !ok {
+							selects[t.Name.String()] = sel
+						}
 					}
-					return value
+					// 派生表由外层遍历处理
+					return false, nil
 				}
+				return true, nil
+			}, sel.From)
+		}
+		return true, nil
+	}, node)
+	return selects
+}
+
+// renameQualifiers 更新查询列、条件、排序、JOIN ON 及子查询中列与 table.* 的表名限定，以别名限定的列不变
+func renameQualifiers(node sqlparser.SQLNode, tableNames map[string]string) {
+	_ = sqlparser.Walk(func(node sqlparser.SQLNode) (bool, error) {
+		switch n := node.(type) {
+		case *sqlparser.ColName:
+			if name, ok := tableNames[n.Qualifier.Name.String()]; ok && !n.Qualifier.IsEmpty() {
+				n.Qualifier.Name = sqlparser.NewTableIdent(name)
+			}
+		case *sqlparser.StarExpr:
+			if name, ok := tableNames[n.TableName.Name.String()]; ok && !n.TableName.IsEmpty() {
+				n.TableName.Name = sqlparser.NewTableIdent(name)
 			}
 		}
-	case *sqlparser.OrExpr:
-	case *sqlparser.AndExpr:
-		result := getSqlParameterValue(node.Left, key)
-		if result != nil {
-			return result
+		return true, nil
+	}, node)
+}
+
+// restorePlaceholder sqlparser生成的sql中，原sql带有?会被替换成:v+数字，需对其做替换
+func restorePlaceholder(sql string) string {
+	return placeholderRegexp.ReplaceAllString(sql, "?")
+}
+
+// placeholderArgs 每个语法节点中占位符对应的参数序号
+func placeholderArgs(nodes ...sqlparser.SQLNode) ([][]int, error) {
+	rowArgs := make([][]int, len(nodes))
+	for i, node := range nodes {
+		err := sqlparser.Walk(func(node sqlparser.SQLNode) (bool, error) {
+			if val, ok := node.(*sqlparser.SQLVal); ok && val.Type == sqlparser.ValArg {
+				index, err := strconv.Atoi(strings.TrimPrefix(string(val.Val), ":v"))
+				if err != nil {
+					return false, err
+				}
+				rowArgs[i] = append(rowArgs[i], index-1)
+			}
+			return true, nil
+		}, node)
+		if err != nil {
+			return nil, err
 		}
-		return getSqlParameterValue(node.Right, key)
-	case *sqlparser.BinaryExpr:
-	case *sqlparser.IsExpr:
 	}
-	return nil
+	return rowArgs, nil
+}
+
+// aggregationType 聚合函数类型
+type aggregationType string
+
+const (
+	aggregationCount aggregationType = "count"
+	aggregationSum   aggregationType = "sum"
+	aggregationMin   aggregationType = "min"
+	aggregationMax   aggregationType = "max"
+	aggregationAvg   aggregationType = "avg"
+)
+
+// parseAggregation 识别查询列中的聚合函数
+func parseAggregation(expr sqlparser.Expr) (*sqlparser.FuncExpr, aggregationType, bool) {
+	fn, ok := expr.(*sqlparser.FuncExpr)
+	if !ok || !fn.Qualifier.IsEmpty() {
+		return nil, "", false
+	}
+	switch t := aggregationType(fn.Name.Lowered()); t {
+	case aggregationCount, aggregationSum, aggregationMin, aggregationMax, aggregationAvg:
+		return fn, t, true
+	}
+	return nil, "", false
+}
+
+// containsAggregation 表达式中是否含有聚合函数，不进入子查询
+func containsAggregation(expr sqlparser.Expr) bool {
+	found := false
+	_ = sqlparser.Walk(func(node sqlparser.SQLNode) (bool, error) {
+		switch n := node.(type) {
+		case *sqlparser.Subquery:
+			return false, nil
+		case *sqlparser.FuncExpr:
+			if _, _, ok := parseAggregation(n); ok {
+				found = true
+				return false, nil
+			}
+		}
+		return !found, nil
+	}, expr)
+	return found
 }
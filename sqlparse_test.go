@@ -0,0 +1,130 @@
+package dbroute
+
+import "testing"
+
+func TestChangeSqlTableNames(t *testing.T) {
+	tableNames := map[string]string{"order": "order_1", "order_item": "order_item_1", "user": "user_0"}
+	tests := []struct {
+		name string
+		sql  string
+		want string
+	}{
+		{
+			name: "select",
+			sql:  "SELECT * FROM `order` WHERE id = ?",
+			want: "select * from order_1 where id = ?",
+		},
+		{
+			name: "alias kept",
+			sql:  "SELECT o.id FROM `order` AS o WHERE o.user_id = ?",
+			want: "select o.id from order_1 as o where o.user_id = ?",
+		},
+		{
+			name: "qualified columns renamed",
+			sql:  "SELECT `order`.id, `order`.* FROM `order` WHERE `order`.user_id = ? ORDER BY `order`.id",
+			want: "select order_1.id, order_1.* from order_1 where order_1.user_id = ? order by order_1.id asc",
+		},
+		{
+			name: "join",
+			sql:  "SELECT * FROM `order` JOIN order_item ON `order`.id = order_item.order_id",
+			want: "select * from order_1 join order_item_1 on order_1.id = order_item_1.order_id",
+		},
+		{
+			name: "join with aliases",
+			sql:  "SELECT * FROM `order` o LEFT JOIN order_item i ON o.id = i.order_id",
+			want: "select * from order_1 as o left join order_item_1 as i on o.id = i.order_id",
+		},
+		{
+			name: "subquery",
+			sql:  "SELECT * FROM `order` WHERE user_id IN (SELECT id FROM `user` WHERE `user`.vip = 1)",
+			want: "select * from order_1 where user_id in (select id from user_0 where user_0.vip = 1)",
+		},
+		{
+			name: "derived table",
+			sql:  "SELECT * FROM (SELECT * FROM `order`) AS t",
+			want: "select * from (select * from order_1) as t",
+		},
+		{
+			name: "union",
+			sql:  "SELECT id FROM `order` UNION SELECT id FROM `user`",
+			want: "select id from order_1 union select id from user_0",
+		},
+		{
+			name: "insert select",
+			sql:  "INSERT INTO `order` (id) SELECT id FROM `user`",
+			want: "insert into order_1(id) select id from user_0",
+		},
+		{
+			name: "insert",
+			sql:  "INSERT INTO `order` (id, user_id) VALUES (?, ?)",
+			want: "insert into order_1(id, user_id) values (?, ?)",
+		},
+		{
+			name: "update",
+			sql:  "UPDATE `order` SET status = ? WHERE id = ?",
+			want: "update order_1 set `status` = ? where id = ?",
+		},
+		{
+			name: "delete",
+			sql:  "DELETE FROM `order` WHERE id = ?",
+			want: "delete from order_1 where id = ?",
+		},
+		{
+			name: "database qualifier kept",
+			sql:  "SELECT * FROM db.`order` WHERE id = 1",
+			want: "select * from db.order_1 where id = 1",
+		},
+		{
+			name: "unmapped table",
+			sql:  "SELECT * FROM other WHERE id = 1",
+			want: "select * from other where id = 1",
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, err := ChangeSqlTableNames(tt.sql, tableNames)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if got != tt.want {
+				t.Errorf("sql = %s, want %s", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestChangeSqlTableName(t *testing.T) {
+	tests := []struct {
+		name    string
+		sql     string
+		want    string
+		wantErr bool
+	}{
+		{
+			name: "main table only",
+			sql:  "SELECT * FROM `order` JOIN order_item ON `order`.id = order_item.order_id",
+			want: "select * from order_3 join order_item on order_3.id = order_item.order_id",
+		},
+		{
+			name: "alias kept",
+			sql:  "SELECT o.* FROM `order` o WHERE o.id = ?",
+			want: "select o.* from order_3 as o where o.id = ?",
+		},
+		{
+			name:    "parse error",
+			sql:     "SELEKT * FROM `order`",
+			wantErr: true,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, err := ChangeSqlTableName(tt.sql, "order_3")
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("err = %v, wantErr %v", err, tt.wantErr)
+			}
+			if got != tt.want {
+				t.Errorf("sql = %s, want %s", got, tt.want)
+			}
+		})
+	}
+}
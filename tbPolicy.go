@@ -4,6 +4,7 @@ import (
 	"context"
 	"fmt"
 	"gorm.io/gorm/logger"
+	"sync"
 )
 
 type ShardingIndexKey string
@@ -16,8 +17,22 @@ type TbPolicy interface {
 }
 
 type TbPolicyResult struct {
+	// ActualTableName 路由到的物理表，为空时不改写表名
 	ActualTableName string
-	Sql             string
+	// Scatter 未命中分表键时需分散执行的全部物理表
+	Scatter []string
+	// Error 无法计算分表时的错误，由路由返回给语句
+	Error error
+	// Deprecated: 路由按 ActualTableName、Scatter 改写表名，不再读取该字段，仅为兼容保留
+	Sql string
+}
+
+// tables 需要执行的全部物理表
+func (r TbPolicyResult) tables() []string {
+	if len(r.Scatter) > 0 {
+		return r.Scatter
+	}
+	return []string{r.ActualTableName}
 }
 
 // TbDefaultPolicy 默认路由，空实现
@@ -34,28 +49,177 @@ type TbShardingRoutePolicy struct {
 	DataShardingRuleModelMap map[string]DataShardingRuleModel
 }
 
+func (p *TbShardingRoutePolicy) validate() error {
+	return validateRules(p.DataShardingRuleModelMap)
+}
+
+// Resolve
+//
+//	@Description: 按分表规则解析物理表，sql为填充了参数值的sql
 func (p *TbShardingRoutePolicy) Resolve(ctx context.Context, tableName string, sql string, log logger.Interface) (result TbPolicyResult) {
 	if _, ok := p.DataShardingRuleModelMap[tableName]; !ok {
-		return TbPolicyResult{Sql: sql}
+		return TbPolicyResult{}
 	} else {
-		// update sql
 		tableIndexVal := ctx.Value(fmt.Sprintf(string(ShardingTableIndex), tableName))
 		if tableIndexVal != nil {
-			index := tableIndexVal.(int)
+			index, ok := tableIndexVal.(int)
+			if !ok {
+				return TbPolicyResult{Error: fmt.Errorf("table pre_set sharding of %s: %T is not an int", tableName, tableIndexVal)}
+			}
 			// 解析得到真正的表名
 			actualTableName := fmt.Sprintf("%v_%v", tableName, index)
 			log.Info(ctx, "table pre_set sharding: %v", actualTableName)
-			return TbPolicyResult{ActualTableName: actualTableName, Sql: ChangeSqlTableName(sql, actualTableName)}
+			return TbPolicyResult{ActualTableName: actualTableName}
 		} else {
 			model := p.DataShardingRuleModelMap[tableName]
-			// 分库键值
-			value := GetSqlParameterValue(sql, model.DatabaseShardingParameter)
-			result := parseExpression(model.TableShardingParameter, model.TableShardingExpression, value)
+			parameters := model.tableShardingParameters()
+			if len(parameters) == 0 {
+				// 仅分库，不改写表名
+				return TbPolicyResult{}
+			}
+			// 分表键条件
+			conds, err := model.shardingConditions(sql, parameters)
+			if err != nil {
+				return TbPolicyResult{Error: fmt.Errorf("table sharding of %s: %w", tableName, err)}
+			}
+			targets, ok, err := conds.targets(model.tableSharding())
+			if err != nil {
+				return TbPolicyResult{Error: fmt.Errorf("table sharding of %s: %w", tableName, err)}
+			}
+			if !ok {
+				// 无法确定分表，分散到全部物理表
+				return scatterTables(ctx, log, tableName, model.ActualTables)
+			}
+			if len(targets) > 1 {
+				log.Info(ctx, "table sharding: %v", targets)
+				return TbPolicyResult{ActualTableName: targets[0], Scatter: targets}
+			}
 			// 解析得到真正的表名
-			actualTableName := result.(string)
+			actualTableName := targets[0]
 			log.Info(ctx, "table sharding: %v", actualTableName)
-			// update sql
-			return TbPolicyResult{ActualTableName: actualTableName, Sql: ChangeSqlTableName(sql, actualTableName)}
+			return TbPolicyResult{ActualTableName: actualTableName}
 		}
 	}
 }
+
+// scatterTables 分散到全部物理表，未配置物理表时返回配置错误，避免在逻辑表上执行
+func scatterTables(ctx context.Context, log logger.Interface, tableName string, actualTables []string) TbPolicyResult {
+	if len(actualTables) == 0 {
+		return TbPolicyResult{Error: fmt.Errorf("table scatter of %s: actual tables not configured", tableName)}
+	}
+	log.Info(ctx, "table scatter: %v", actualTables)
+	return TbPolicyResult{Scatter: actualTables}
+}
+
+// ActualTables 分表规则中配置的全部物理表
+func (p *TbShardingRoutePolicy) ActualTables(tableName string) []string {
+	return p.DataShardingRuleModelMap[tableName].ActualTables
+}
+
+// TbIntervalPolicy 按时间分表，如 event_202609、event_202610
+type TbIntervalPolicy struct {
+	// 逻辑表对应的时间分表规则
+	Rules map[string]TbIntervalRule
+	// compiled 逻辑表对应的已解析规则 *compiledIntervalRule，注册时解析，执行时复用
+	compiled sync.Map
+}
+
+// compiledIntervalRule 解析后的时间分表规则
+type compiledIntervalRule struct {
+	algorithm *intervalAlgorithm
+	prefix    string
+}
+
+// TbIntervalRule 时间分表规则，分片列的时间按 INTERVAL 算法属性划分，物理表名为 前缀+后缀
+type TbIntervalRule struct {
+	// Column 分片列
+	Column string `json:"column"`
+	// Prefix 物理表名前缀，默认为 表名_
+	Prefix string `json:"prefix"`
+	// Props 时间格式、后缀格式、间隔、时区及起止时间，未配置结束时间时以当前时间为上界
+	Props ShardingAlgorithmProps `json:"props"`
+}
+
+// validate 校验全部时间分表规则
+func (p *TbIntervalPolicy) validate() error {
+	for table := range p.Rules {
+		if _, _, err := p.rule(table); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// rule 逻辑表的时间分表规则，首次使用时解析并缓存
+func (p *TbIntervalPolicy) rule(tableName string) (*intervalAlgorithm, string, error) {
+	if compiled, ok := p.compiled.Load(tableName); ok {
+		rule := compiled.(*compiledIntervalRule)
+		return rule.algorithm, rule.prefix, nil
+	}
+	algorithm, prefix, err := p.Rules[tableName].compile(tableName)
+	if err != nil {
+		return nil, "", err
+	}
+	p.compiled.Store(tableName, &compiledIntervalRule{algorithm: algorithm, prefix: prefix})
+	return algorithm, prefix, nil
+}
+
+// Resolve
+//
+//	@Description: 按分片列的取值或范围解析涉及的全部时间分表，范围限定在起止时间内，无法确定时分散到起止时间内的全部分表，
+//	取值均超出起止时间时返回错误
+func (p *TbIntervalPolicy) Resolve(ctx context.Context, tableName string, sql string, log logger.Interface) (result TbPolicyResult) {
+	rule, ok := p.Rules[tableName]
+	if !ok {
+		return TbPolicyResult{}
+	}
+	algorithm, prefix, err := p.rule(tableName)
+	if err != nil {
+		return TbPolicyResult{Error: err}
+	}
+	cond, err := GetSqlShardingCondition(sql, rule.Column)
+	if err != nil {
+		return TbPolicyResult{Error: err}
+	}
+	var tables []string
+	for _, suffix := range algorithm.conditionSuffixes(cond) {
+		tables = append(tables, prefix+suffix)
+	}
+	if len(tables) == 0 {
+		return TbPolicyResult{Error: fmt.Errorf("%s of %s is out of the interval range, no actual table matched", rule.Column, tableName)}
+	}
+	log.Info(ctx, "table sharding: %v", tables)
+	if len(tables) == 1 {
+		return TbPolicyResult{ActualTableName: tables[0]}
+	}
+	return TbPolicyResult{ActualTableName: tables[0], Scatter: tables}
+}
+
+// ActualTables 起止时间内的全部时间分表，未配置结束时间时截至当前时间
+func (p *TbIntervalPolicy) ActualTables(tableName string) []string {
+	if _, ok := p.Rules[tableName]; !ok {
+		return nil
+	}
+	algorithm, prefix, err := p.rule(tableName)
+	if err != nil {
+		return nil
+	}
+	var tables []string
+	for _, suffix := range algorithm.rangeSuffixes(algorithm.lower, algorithm.upperBound()) {
+		tables = append(tables, prefix+suffix)
+	}
+	return tables
+}
+
+// compile 解析时间分表规则，返回分片算法及物理表名前缀
+func (rule TbIntervalRule) compile(tableName string) (*intervalAlgorithm, string, error) {
+	algorithm, err := compileIntervalAlgorithm(rule.Props)
+	if err != nil {
+		return nil, "", fmt.Errorf("interval rule of %s: %w", tableName, err)
+	}
+	prefix := rule.Prefix
+	if prefix == "" {
+		prefix = tableName + "_"
+	}
+	return algorithm, prefix, nil
+}
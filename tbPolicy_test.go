@@ -0,0 +1,92 @@
+package dbroute
+
+import (
+	"context"
+	"reflect"
+	"testing"
+
+	"gorm.io/gorm/logger"
+)
+
+func TestTbShardingRoutePolicyWithoutActualTables(t *testing.T) {
+	policy := &TbShardingRoutePolicy{DataShardingRuleModelMap: map[string]DataShardingRuleModel{"order": {
+		Table:                   "order",
+		TableShardingParameter:  "user_id",
+		TableShardingExpression: "order_${user_id % 2}",
+	}}}
+	if err := policy.validate(); err != nil {
+		t.Fatalf("validate = %v, want nil", err)
+	}
+	tests := []struct {
+		name    string
+		sql     string
+		want    string
+		wantErr bool
+	}{
+		{name: "sharding key", sql: "SELECT * FROM `order` WHERE user_id = 3", want: "order_1"},
+		{name: "scatter", sql: "SELECT * FROM `order`", wantErr: true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			result := policy.Resolve(context.Background(), "order", tt.sql, logger.Discard)
+			if (result.Error != nil) != tt.wantErr {
+				t.Fatalf("err = %v, wantErr %v", result.Error, tt.wantErr)
+			}
+			if result.ActualTableName != tt.want {
+				t.Errorf("table = %s, want %s", result.ActualTableName, tt.want)
+			}
+		})
+	}
+}
+
+func TestTbIntervalPolicy(t *testing.T) {
+	policy := &TbIntervalPolicy{Rules: map[string]TbIntervalRule{"event": {
+		Column: "created_at",
+		Props: ShardingAlgorithmProps{
+			DatetimePattern:       "2006-01-02 15:04:05",
+			DatetimeLower:         "2026-01-01 00:00:00",
+			DatetimeUpper:         "2026-06-30 23:59:59",
+			ShardingSuffixPattern: "200601",
+			DatetimeIntervalUnit:  IntervalMonths,
+		},
+	}}}
+	if err := policy.validate(); err != nil {
+		t.Fatal(err)
+	}
+	all := []string{"event_202601", "event_202602", "event_202603", "event_202604", "event_202605", "event_202606"}
+	if got := policy.ActualTables("event"); !reflect.DeepEqual(got, all) {
+		t.Errorf("ActualTables() = %v, want %v", got, all)
+	}
+	tests := []struct {
+		name    string
+		where   string
+		want    []string
+		wantErr bool
+	}{
+		{name: "equal", where: "created_at = '2026-03-05 10:00:00'", want: []string{"event_202603"}},
+		{name: "between across months", where: "created_at BETWEEN '2026-02-15 00:00:00' AND '2026-04-01 00:00:00'", want: []string{"event_202602", "event_202603", "event_202604"}},
+		{name: "in across months", where: "created_at IN ('2026-05-01 00:00:00', '2026-01-31 23:59:59')", want: []string{"event_202601", "event_202605"}},
+		{name: "start bound", where: "created_at = '2026-01-01 00:00:00'", want: []string{"event_202601"}},
+		{name: "end bound", where: "created_at = '2026-06-30 23:59:59'", want: []string{"event_202606"}},
+		{name: "range clipped to start", where: "created_at BETWEEN '2025-11-01 00:00:00' AND '2026-01-31 23:59:59'", want: []string{"event_202601"}},
+		{name: "range clipped to end", where: "created_at >= '2026-05-20 00:00:00'", want: []string{"event_202605", "event_202606"}},
+		{name: "no condition", where: "id = 1", want: all},
+		{name: "before start", where: "created_at = '2025-12-31 23:59:59'", wantErr: true},
+		{name: "after end", where: "created_at = '2026-07-01 00:00:00'", wantErr: true},
+		{name: "range out of range", where: "created_at BETWEEN '2027-01-01 00:00:00' AND '2027-02-01 00:00:00'", wantErr: true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			result := policy.Resolve(context.Background(), "event", "SELECT * FROM event WHERE "+tt.where, logger.Discard)
+			if (result.Error != nil) != tt.wantErr {
+				t.Fatalf("err = %v, wantErr %v", result.Error, tt.wantErr)
+			}
+			if tt.wantErr {
+				return
+			}
+			if got := result.tables(); !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("tables = %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
@@ -0,0 +1,104 @@
+package dbroute
+
+import (
+	"context"
+	"database/sql"
+	"gorm.io/gorm"
+	"sync"
+)
+
+// txConnPool 默认连接池，在其上开启的事务可将路由到其它数据源的语句在对应数据源的事务中执行
+type txConnPool struct {
+	gorm.ConnPool
+}
+
+func (p *txConnPool) BeginTx(ctx context.Context, opt *sql.TxOptions) (gorm.ConnPool, error) {
+	tx, err := beginTx(ctx, p.ConnPool, opt)
+	if err != nil {
+		return nil, err
+	}
+	return &routeTx{ConnPool: tx, connPool: unwrapPrepared(p.ConnPool), ctx: ctx, opt: opt}, nil
+}
+
+// routeTx
+//
+//	@Description: 开启在默认连接上的事务，如 gorm 默认事务及 CreateInBatches 多批次时的事务。
+//	路由到其它数据源的语句在该数据源上随本事务开启的事务中执行，提交时先逐个提交各数据源的事务，
+//	再提交默认连接上的事务；提交为尽力而为（非 XA），某个事务提交失败时回滚其余未提交的事务，
+//	错误中列出已提交的数据源。保存点仅作用于默认连接上的事务
+type routeTx struct {
+	gorm.ConnPool
+	// 默认连接池
+	connPool gorm.ConnPool
+	ctx      context.Context
+	opt      *sql.TxOptions
+	mu       sync.Mutex
+	// 随本事务开启的其它数据源的事务，按开启顺序
+	joined []routeUnit
+}
+
+// join 路由单元在本事务中使用的连接，默认连接池使用本事务，其它数据源首次使用时开启事务
+func (tx *routeTx) join(unit routeUnit) (gorm.ConnPool, error) {
+	if unwrapPrepared(unit.ConnPool) == tx.connPool {
+		return tx, nil
+	}
+	tx.mu.Lock()
+	defer tx.mu.Unlock()
+	for _, joined := range tx.joined {
+		if joined.Name == unit.Name {
+			return joined.ConnPool, nil
+		}
+	}
+	connPool, err := beginTx(tx.ctx, unit.ConnPool, tx.opt)
+	if err != nil {
+		return nil, err
+	}
+	tx.joined = append(tx.joined, routeUnit{Name: unit.Name, ConnPool: connPool})
+	return connPool, nil
+}
+
+func (tx *routeTx) Commit() error {
+	tx.mu.Lock()
+	defer tx.mu.Unlock()
+	units := append(append([]routeUnit{}, tx.joined...), routeUnit{Name: Default, ConnPool: tx.ConnPool})
+	txs := make([]gorm.TxCommitter, len(units))
+	for i, unit := range units {
+		txs[i] = unit.ConnPool.(gorm.TxCommitter)
+	}
+	return (&shardingConnPool{units: units}).finishTxs(txs, nil)
+}
+
+func (tx *routeTx) Rollback() error {
+	tx.mu.Lock()
+	defer tx.mu.Unlock()
+	for _, unit := range tx.joined {
+		_ = unit.ConnPool.(gorm.TxCommitter).Rollback()
+	}
+	return tx.ConnPool.(gorm.TxCommitter).Rollback()
+}
+
+// StmtContext 供 gorm 在事务中使用预编译语句
+func (tx *routeTx) StmtContext(ctx context.Context, stmt *sql.Stmt) *sql.Stmt {
+	if stmtTx, ok := tx.ConnPool.(interface {
+		StmtContext(context.Context, *sql.Stmt) *sql.Stmt
+	}); ok {
+		return stmtTx.StmtContext(ctx, stmt)
+	}
+	return stmt
+}
+
+// joinTx 事务中路由单元使用的连接，非 routeTx 的事务仅能在开启事务的连接上执行
+func joinTx(connPool gorm.ConnPool, unit routeUnit) (gorm.ConnPool, error) {
+	if tx, ok := connPool.(*routeTx); ok {
+		return tx.join(unit)
+	}
+	return connPool, nil
+}
+
+// unwrapPrepared 预编译连接池包装的原连接池
+func unwrapPrepared(connPool gorm.ConnPool) gorm.ConnPool {
+	if preparedStmtDB, ok := connPool.(*gorm.PreparedStmtDB); ok {
+		return preparedStmtDB.ConnPool
+	}
+	return connPool
+}
@@ -0,0 +1,158 @@
+package dbroute
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"reflect"
+	"testing"
+
+	"gorm.io/gorm"
+	"gorm.io/gorm/callbacks"
+	"gorm.io/gorm/clause"
+	"gorm.io/gorm/logger"
+	"gorm.io/gorm/schema"
+)
+
+// testDialector 不连接数据库的方言，语句由测试连接池处理
+type testDialector struct {
+	pool gorm.ConnPool
+}
+
+func (d testDialector) Name() string {
+	return "test"
+}
+
+func (d testDialector) Initialize(db *gorm.DB) error {
+	callbacks.RegisterDefaultCallbacks(db, &callbacks.Config{})
+	db.ConnPool = d.pool
+	return nil
+}
+
+func (d testDialector) Migrator(*gorm.DB) gorm.Migrator {
+	return nil
+}
+
+func (d testDialector) DataTypeOf(*schema.Field) string {
+	return ""
+}
+
+func (d testDialector) DefaultValueOf(*schema.Field) clause.Expression {
+	return clause.Expr{SQL: "DEFAULT"}
+}
+
+func (d testDialector) BindVarTo(writer clause.Writer, _ *gorm.Statement, _ interface{}) {
+	_ = writer.WriteByte('?')
+}
+
+func (d testDialector) QuoteTo(writer clause.Writer, str string) {
+	_ = writer.WriteByte('`')
+	_, _ = writer.WriteString(str)
+	_ = writer.WriteByte('`')
+}
+
+func (d testDialector) Explain(sql string, vars ...interface{}) string {
+	return logger.ExplainSQL(sql, nil, `'`, vars...)
+}
+
+// testTxPool 记录已提交语句的连接池，参数含 failArg 时执行失败
+type testTxPool struct {
+	testConnPool
+	failArg    interface{}
+	committed  []interface{}
+	rolledBack int
+}
+
+func (p *testTxPool) BeginTx(context.Context, *sql.TxOptions) (gorm.ConnPool, error) {
+	return &testTxConn{pool: p}, nil
+}
+
+// testTxConn testTxPool 上开启的事务，提交时将执行的语句参数记录到连接池
+type testTxConn struct {
+	testConnPool
+	pool    *testTxPool
+	pending []interface{}
+}
+
+func (tx *testTxConn) ExecContext(_ context.Context, _ string, args ...interface{}) (sql.Result, error) {
+	for _, arg := range args {
+		if tx.pool.failArg != nil && arg == tx.pool.failArg {
+			return nil, errors.New("exec failed")
+		}
+	}
+	tx.pending = append(tx.pending, args...)
+	return testInsertResult(0), nil
+}
+
+func (tx *testTxConn) Commit() error {
+	tx.pool.committed = append(tx.pool.committed, tx.pending...)
+	return nil
+}
+
+func (tx *testTxConn) Rollback() error {
+	tx.pool.rolledBack++
+	return nil
+}
+
+// TestCreateInBatchesTransaction CreateInBatches 多批次时在默认事务中按分片写入各数据源，随默认事务提交或回滚
+func TestCreateInBatchesTransaction(t *testing.T) {
+	tests := []struct {
+		name         string
+		failArg      interface{}
+		wantErr      bool
+		wantDs0      []interface{}
+		wantDs1      []interface{}
+		wantRollback bool
+	}{
+		{
+			name:    "committed with default transaction",
+			wantDs0: []interface{}{int64(2), int64(2), int64(4), int64(4)},
+			wantDs1: []interface{}{int64(1), int64(1), int64(3), int64(3), int64(5), int64(5)},
+		},
+		{
+			name:         "later batch failure rolls back all data sources",
+			failArg:      int64(4),
+			wantErr:      true,
+			wantRollback: true,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			def := &testTxPool{testConnPool: testConnPool{name: "default"}}
+			ds0 := &testTxPool{testConnPool: testConnPool{name: "ds_0"}, failArg: tt.failArg}
+			ds1 := &testTxPool{testConnPool: testConnPool{name: "ds_1"}}
+			db, err := gorm.Open(testDialector{pool: def}, &gorm.Config{Logger: logger.Discard})
+			if err != nil {
+				t.Fatal(err)
+			}
+			rules := map[string]DataShardingRuleModel{"test_orders": {
+				Table:                      "test_orders",
+				DatabaseShardingParameter:  "user_id",
+				DatabaseShardingExpression: "ds_${user_id % 2}",
+			}}
+			err = db.Use(Register(Config{
+				Masters: map[ShardingName]DialectorConfig{
+					"ds_0": {Dialector: []gorm.Dialector{testDialector{pool: ds0}}},
+					"ds_1": {Dialector: []gorm.Dialector{testDialector{pool: ds1}}},
+				},
+				DbPolicy: &DbShardingRoutePolicy{DataShardingRuleModelMap: rules},
+			}, "test_orders"))
+			if err != nil {
+				t.Fatal(err)
+			}
+			orders := []testOrder{{ID: 1, UserID: 1}, {ID: 2, UserID: 2}, {ID: 3, UserID: 3}, {ID: 4, UserID: 4}, {ID: 5, UserID: 5}}
+			if err = db.CreateInBatches(&orders, 2).Error; (err != nil) != tt.wantErr {
+				t.Fatalf("CreateInBatches() error = %v, wantErr %v", err, tt.wantErr)
+			}
+			if !reflect.DeepEqual(ds0.committed, tt.wantDs0) || !reflect.DeepEqual(ds1.committed, tt.wantDs1) {
+				t.Errorf("committed ds_0 = %v, ds_1 = %v, want %v, %v", ds0.committed, ds1.committed, tt.wantDs0, tt.wantDs1)
+			}
+			if def.committed != nil {
+				t.Errorf("committed on default = %v", def.committed)
+			}
+			if rolledBack := ds0.rolledBack > 0 && ds1.rolledBack > 0 && def.rolledBack > 0; rolledBack != tt.wantRollback {
+				t.Errorf("rolled back = %v, want %v", rolledBack, tt.wantRollback)
+			}
+		})
+	}
+}
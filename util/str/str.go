@@ -1,9 +1,11 @@
 package str
 
 import (
+	"encoding/binary"
 	"encoding/json"
 	"fmt"
 	"math"
+	"math/bits"
 )
 
 // Hashcode 计算字符串的hashcode
@@ -30,3 +32,43 @@ func ConvertStrToStruct(str string, v any) error {
 	}
 	return nil
 }
+
+// Murmur3 计算字符串 UTF-8 字节的 MurmurHash3 x86_32，与 Guava Hashing.murmur3_32(seed).hashString(s, UTF_8).asInt() 一致
+func Murmur3(s string, seed uint32) int32 {
+	const c1, c2 = 0xcc9e2d51, 0x1b873593
+	data := []byte(s)
+	h := seed
+	n := len(data) / 4
+	for i := 0; i < n; i++ {
+		k := binary.LittleEndian.Uint32(data[i*4:])
+		k *= c1
+		k = bits.RotateLeft32(k, 15)
+		k *= c2
+		h ^= k
+		h = bits.RotateLeft32(h, 13)
+		h = h*5 + 0xe6546b64
+	}
+	var k uint32
+	tail := data[n*4:]
+	switch len(tail) {
+	case 3:
+		k ^= uint32(tail[2]) << 16
+		fallthrough
+	case 2:
+		k ^= uint32(tail[1]) << 8
+		fallthrough
+	case 1:
+		k ^= uint32(tail[0])
+		k *= c1
+		k = bits.RotateLeft32(k, 15)
+		k *= c2
+		h ^= k
+	}
+	h ^= uint32(len(data))
+	h ^= h >> 16
+	h *= 0x85ebca6b
+	h ^= h >> 13
+	h *= 0xc2b2ae35
+	h ^= h >> 16
+	return int32(h)
+}
@@ -0,0 +1,74 @@
+package str
+
+import "testing"
+
+// 期望值为 Java String.hashCode()
+func TestHashcode(t *testing.T) {
+	tests := []struct {
+		s    string
+		want int32
+	}{
+		{"", 0},
+		{"abc", 96354},
+		{"hello", 99162322},
+		{"user_1", -836030275},
+		{"polygenelubricants", -2147483648},
+		{"中文", 646394},
+	}
+	for _, tt := range tests {
+		t.Run(tt.s, func(t *testing.T) {
+			if got := Hashcode(tt.s); got != tt.want {
+				t.Errorf("Hashcode(%q) = %d, want %d", tt.s, got, tt.want)
+			}
+		})
+	}
+}
+
+// 期望值为 Java Math.abs(s.hashCode() % num)
+func TestHashMode(t *testing.T) {
+	tests := []struct {
+		s    string
+		num  int32
+		want int
+	}{
+		{"hello", 4, 2},
+		{"user_1", 16, 3},
+		{"polygenelubricants", 7, 2},
+	}
+	for _, tt := range tests {
+		t.Run(tt.s, func(t *testing.T) {
+			if got := HashMode(tt.s, tt.num); got != tt.want {
+				t.Errorf("HashMode(%q, %d) = %d, want %d", tt.s, tt.num, got, tt.want)
+			}
+		})
+	}
+}
+
+// 期望值为 MurmurHash3 x86_32 的参考向量，seed 为 0 时同 Guava Hashing.murmur3_32().hashString(s, UTF_8).asInt()
+func TestMurmur3(t *testing.T) {
+	tests := []struct {
+		s    string
+		seed uint32
+		want uint32
+	}{
+		{"", 0, 0},
+		{"", 1, 0x514e28b7},
+		{"", 0xffffffff, 0x81f16f39},
+		{"\x00\x00\x00\x00", 0, 0x2362f9de},
+		{"a", 0x9747b28c, 0x7fa09ea6},
+		{"aa", 0x9747b28c, 0x5d211726},
+		{"aaa", 0x9747b28c, 0x283e0130},
+		{"aaaa", 0x9747b28c, 0x5a97808a},
+		{"abcd", 0x9747b28c, 0xf0478627},
+		{"Hello, world!", 0x9747b28c, 0x24884cba},
+		{"The quick brown fox jumps over the lazy dog", 0, 0x2e4ff723},
+		{"The quick brown fox jumps over the lazy dog", 0x9747b28c, 0x2fa826cd},
+	}
+	for _, tt := range tests {
+		t.Run(tt.s, func(t *testing.T) {
+			if got := uint32(Murmur3(tt.s, tt.seed)); got != tt.want {
+				t.Errorf("Murmur3(%q, %#x) = %#x, want %#x", tt.s, tt.seed, got, tt.want)
+			}
+		})
+	}
+}